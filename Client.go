@@ -1,38 +1,360 @@
 package mbsyslog
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
+//dialTimeout is how long a client waits for a stream connection to open
+const dialTimeout = 30 * time.Second
+
+//queueRetryInterval is how long a client waits before sending the oldest
+//message in its disk queue again after it failed
+const queueRetryInterval = time.Second
+
 //Client is a syslog client to send messages to syslog servers
 type Client struct {
-	syncSend   bool
-	wg         sync.WaitGroup
-	asyncError error
-	mutex      *sync.Mutex
+	syncSend       bool
+	transport      Transport
+	tlsConfig      *tls.Config
+	destinations   []*destination
+	format         MessageFormat
+	facility       MessageFacility
+	hostname       string
+	application    string
+	processID      int
+	sending        int
+	idle           chan struct{}
+	asyncError     error
+	mutex          *sync.Mutex
+	connections    map[string]*connection
+	datagrams      map[string]*datagramSocket
+	datagramSweep  time.Time
+	sessions       map[string]*relpSession
+	dnsCacheTTL    time.Duration
+	connMutex      *sync.Mutex
+	batchSize      int
+	batchInterval  time.Duration
+	limiter        *rateLimiter
+	queue          *DiskQueue
+	retry          RetryPolicy
+	healthInterval time.Duration
+	healthCheck    func(ctx context.Context, addr string) error
+	errorHandler   func(*SendError)
+}
+
+//SendError reports a message the client failed to send
+type SendError struct {
+	//Address is where the message was sent, or empty for the destinations of
+	//the client
+	Address string
+	//Data is the message, or the framed messages of a batch that failed to
+	//write
+	Data []byte
+	//Err is the error of the last attempt
+	Err error
+}
+
+func (e *SendError) Error() string {
+	if e.Address == "" {
+		return fmt.Sprintf("Failed to send to the destinations: %s", e.Err)
+	}
+	return fmt.Sprintf("Failed to send to %s: %s", e.Address, e.Err)
+}
+
+func (e *SendError) Unwrap() error {
+	return e.Err
+}
+
+//ClientOption configures optional behaviour of a client
+type ClientOption func(*Client)
+
+//WithTransport selects the network transport used to send messages. The
+//default transport is UDP.
+func WithTransport(transport Transport) ClientOption {
+	return func(c *Client) {
+		c.transport = transport
+	}
+}
+
+//WithTLSConfig sets the TLS configuration used by the TLS transport. Provide
+//client certificates in the configuration to use mutual TLS.
+func WithTLSConfig(config *tls.Config) ClientOption {
+	return func(c *Client) {
+		c.tlsConfig = config
+	}
+}
+
+//WithDestination sets the address Send and the logging methods deliver
+//messages to, in the same form as the SendData address. The Unix transport
+//doesn't need a destination, see TransportUnix. Use WithDestinations to fail
+//over between several.
+func WithDestination(addr string) ClientOption {
+	return func(c *Client) {
+		c.destinations = nil
+		if addr != "" {
+			c.destinations = []*destination{newDestination(Destination{Address: addr})}
+		}
+	}
+}
+
+//WithMessageFormat selects the format Send writes messages in, either
+//MessageFormatRFC5424 or MessageFormatRFC3164. The default is RFC 5424. The
+//Unix transport always uses the local format of glibc syslog(3), which is
+//RFC 3164 without the hostname.
+func WithMessageFormat(format MessageFormat) ClientOption {
+	return func(c *Client) {
+		c.format = format
+	}
+}
+
+//WithFacility sets the facility of messages from the logging methods. The
+//default is MessageFacilityUser.
+func WithFacility(facility MessageFacility) ClientOption {
+	return func(c *Client) {
+		c.facility = facility
+	}
+}
+
+//WithHostname sets the hostname of messages from the logging methods. The
+//default is the hostname reported by the operating system.
+func WithHostname(hostname string) ClientOption {
+	return func(c *Client) {
+		c.hostname = hostname
+	}
+}
+
+//WithApplication sets the application of messages from the logging methods.
+//The default is the name of the running program.
+func WithApplication(application string) ClientOption {
+	return func(c *Client) {
+		c.application = application
+	}
+}
+
+//WithProcessID sets the process ID of messages from the logging methods, or
+//-1 to leave it out. The default is the ID of the running process.
+func WithProcessID(processID int) ClientOption {
+	return func(c *Client) {
+		c.processID = processID
+	}
+}
+
+//WithDiskQueue stores messages in the disk queue until they are delivered.
+//SendData returns once the message is in the queue, or ErrQueueFull, and a
+//background goroutine delivers the queued messages in order, retrying the
+//oldest until it succeeds. Delivery stops when the queue is closed.
+func WithDiskQueue(queue *DiskQueue) ClientOption {
+	return func(c *Client) {
+		c.queue = queue
+	}
+}
+
+//WithBatching collects the messages sent over TCP and TLS into larger writes.
+//A batch is written once it reaches the size in bytes, or when the interval
+//has passed since the first message in it, so no message waits longer than
+//the interval. A zero interval waits 10 milliseconds.
+//
+//A message counts as sent once it is in a batch. When a batch fails to write,
+//the send that filled it returns the error and its message is taken out of
+//the batch to be sent again, while the rest of the batch is kept and written
+//again after the interval. Flush returns the errors writing batches, and the
+//writes after the interval report them as asynchronous errors with the batch
+//as the data. A batch Close can't write is dropped and reported the same way.
+//Call Flush or Close to write the batches before exiting.
+//
+//Messages from a disk queue are written straight away with the batch before
+//them, so they stay in the queue until they have been sent.
+func WithBatching(size int, interval time.Duration) ClientOption {
+	return func(c *Client) {
+		if interval <= 0 {
+			interval = 10 * time.Millisecond
+		}
+		c.batchSize = size
+		c.batchInterval = interval
+	}
+}
+
+//WithErrorHandler sets a function called with every message that failed to
+//send asynchronously, including each failed attempt to deliver a message from
+//a disk queue. It is called from the goroutine that sent the message.
+func WithErrorHandler(handler func(*SendError)) ClientOption {
+	return func(c *Client) {
+		c.errorHandler = handler
+	}
 }
 
 //NewClient prepares a client to send messages
-func NewClient(syncSend bool) *Client {
+func NewClient(syncSend bool, options ...ClientOption) *Client {
 	result := new(Client)
 	result.syncSend = syncSend
+	result.transport = TransportUDP
+	result.format = MessageFormatRFC5424
+	result.facility = MessageFacilityUser
+	result.processID = os.Getpid()
+
+	//leave out defaults that can't be sent, such as a program name with spaces
+	if hostname, err := os.Hostname(); err == nil && isPrintASCII(hostname, 255) {
+		result.hostname = hostname
+	}
+	if application := filepath.Base(os.Args[0]); isPrintASCII(application, 48) {
+		result.application = application
+	}
 	result.asyncError = nil
 	result.mutex = &sync.Mutex{}
+	result.connections = make(map[string]*connection)
+	result.datagrams = make(map[string]*datagramSocket)
+	result.sessions = make(map[string]*relpSession)
+	result.dnsCacheTTL = defaultDNSCacheTTL
+	result.connMutex = &sync.Mutex{}
+	result.healthInterval = defaultHealthInterval
+
+	for _, option := range options {
+		option(result)
+	}
+	if result.queue != nil {
+		go result.deliverQueue()
+	}
 	return result
 }
 
-//SendData sends raw data messages to remote IP or hostname address
+//SendData sends raw data messages to remote IP or hostname address. The
+//address may include a port, otherwise the default port for the transport is
+//used. IPv6 addresses must be in brackets when a port is included, such as
+//[2001:db8::1]:514. Stream transports keep one connection open for each address.
+//An empty address sends to the destinations of the client.
+//
+//For the Unix transport the address is the path of the socket. An empty path
+//without destinations tries /dev/log, /var/run/syslog and /var/run/log in
+//turn. Like log/syslog, a datagram socket is tried before a stream socket.
 func (c *Client) SendData(addr string, data []byte) error {
+	return c.SendDataContext(context.Background(), addr, data)
+}
+
+//SendDataContext is SendData with a context that limits how long sending and
+//retrying the message may take. An asynchronous send carries on after the
+//call returns, until the message is sent or the context is done. The context
+//isn't used once a message is in a disk queue.
+func (c *Client) SendDataContext(ctx context.Context, addr string, data []byte) error {
+	if c.queue != nil {
+		return c.queue.put(addr, data)
+	}
 	if c.syncSend {
-		return c.syncSendData(addr, data)
+		return c.deliver(ctx, addr, data, false)
 	}
 
-	c.asyncSendData(addr, data)
+	c.asyncSendData(ctx, addr, data)
 	return nil
 }
 
+//Send delivers the message to the destinations set with WithDestination or
+//WithDestinations
+func (c *Client) Send(m *Message) error {
+	return c.SendContext(context.Background(), m)
+}
+
+//SendContext is Send with a context, as for SendDataContext. A message
+//suppressed by the rate limit isn't sent, and nil is returned.
+func (c *Client) SendContext(ctx context.Context, m *Message) error {
+	if len(c.destinations) == 0 && c.transport != TransportUnix {
+		return errors.New("No destination set for the client")
+	}
+	if !c.allow(m.Severity()) {
+		return nil
+	}
+	return c.sendMessage(ctx, m)
+}
+
+//sendMessage writes the message in the client's format and sends it to the
+//destinations
+func (c *Client) sendMessage(ctx context.Context, m *Message) error {
+	var data []byte
+	var err error
+	switch {
+	case c.transport == TransportUnix:
+		data, err = m.marshalLocal()
+	case c.format == MessageFormatRFC3164:
+		data, err = m.MarshalRFC3164()
+	default:
+		data, err = m.MarshalRFC5424()
+	}
+	if err != nil {
+		return err
+	}
+	return c.SendDataContext(ctx, "", data)
+}
+
+//Log sends the content with the severity, filling in the current time and the
+//client's facility, hostname, application and process ID
+func (c *Client) Log(severity MessageSeverity, content string) error {
+	m, err := c.newMessageBuilder(severity).Content(content).Build()
+	if err != nil {
+		return err
+	}
+	return c.Send(m)
+}
+
+//Emerg logs a message with MessageSeverityEmergency
+func (c *Client) Emerg(content string) error {
+	return c.Log(MessageSeverityEmergency, content)
+}
+
+//Alert logs a message with MessageSeverityAlert
+func (c *Client) Alert(content string) error {
+	return c.Log(MessageSeverityAlert, content)
+}
+
+//Crit logs a message with MessageSeverityCritical
+func (c *Client) Crit(content string) error {
+	return c.Log(MessageSeverityCritical, content)
+}
+
+//Err logs a message with MessageSeverityError
+func (c *Client) Err(content string) error {
+	return c.Log(MessageSeverityError, content)
+}
+
+//Warning logs a message with MessageSeverityWarning
+func (c *Client) Warning(content string) error {
+	return c.Log(MessageSeverityWarning, content)
+}
+
+//Notice logs a message with MessageSeverityNotice
+func (c *Client) Notice(content string) error {
+	return c.Log(MessageSeverityNotice, content)
+}
+
+//Info logs a message with MessageSeverityInformational
+func (c *Client) Info(content string) error {
+	return c.Log(MessageSeverityInformational, content)
+}
+
+//Debug logs a message with MessageSeverityDebug
+func (c *Client) Debug(content string) error {
+	return c.Log(MessageSeverityDebug, content)
+}
+
+//newMessageBuilder starts a message with the client's defaults
+func (c *Client) newMessageBuilder(severity MessageSeverity) *MessageBuilder {
+	return NewMessageBuilder().
+		Facility(c.facility).
+		Severity(severity).
+		Timestamp(time.Now()).
+		Hostname(c.hostname).
+		Application(c.application).
+		ProcessID(c.processID)
+}
+
 //AsyncError returns the last error from an asynchronous operation
 func (c *Client) AsyncError() error {
 	c.mutex.Lock()
@@ -40,44 +362,300 @@ func (c *Client) AsyncError() error {
 	return c.asyncError
 }
 
-//Wait for all current asynchronous operations to complete
+//Wait for all current asynchronous operations to complete. With a disk queue
+//this waits until every queued message has been delivered.
 func (c *Client) Wait() {
-	c.wg.Wait()
+	c.WaitContext(context.Background())
 }
 
-func (c *Client) syncSendData(addr string, data []byte) error {
-	udpAddr, err := net.ResolveUDPAddr("udp", addr+":514")
-	if err != nil {
-		return err
+//WaitContext is Wait that gives up when the context is done, returning the
+//context error
+func (c *Client) WaitContext(ctx context.Context) error {
+	select {
+	case <-c.idleChannel():
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+	if c.queue != nil {
+		return c.queue.drained(ctx)
+	}
+	return nil
+}
 
-	conn, err := net.DialUDP("udp", nil, udpAddr)
-	if err != nil {
-		return err
+//idleChannel returns a channel that is closed once there are no asynchronous
+//sends in progress
+func (c *Client) idleChannel() <-chan struct{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.idle == nil {
+		c.idle = make(chan struct{})
+		if c.sending == 0 {
+			close(c.idle)
+		}
 	}
-	defer conn.Close()
+	return c.idle
+}
 
-	count, err := conn.Write(data)
-	if err != nil {
-		return err
+//Flush writes the messages waiting in batches, see WithBatching
+func (c *Client) Flush() error {
+	c.connMutex.Lock()
+	connections := make([]*connection, 0, len(c.connections))
+	for _, conn := range c.connections {
+		connections = append(connections, conn)
 	}
+	c.connMutex.Unlock()
 
-	if count != len(data) {
-		return errors.New("Wrong number of bytes written")
+	var result error
+	for _, conn := range connections {
+		if err := conn.flush(context.Background()); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+//Close closes any connections and sockets held open by the client, writing
+//the messages waiting in batches and any report of suppressed messages first.
+//A later send will open them again.
+func (c *Client) Close() error {
+	if c.limiter != nil {
+		c.closeReport()
+	}
+
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+
+	var result error
+	for addr, conn := range c.connections {
+		if err := conn.close(); err != nil && result == nil {
+			result = err
+		}
+		delete(c.connections, addr)
+	}
+	for addr, socket := range c.datagrams {
+		socket.shutdown()
+		delete(c.datagrams, addr)
+	}
+	for addr, session := range c.sessions {
+		session.close()
+		delete(c.sessions, addr)
+	}
+	return result
+}
+
+//deliver sends the data to the address, or fails over between the
+//destinations when the address is empty, retrying as set by the retry policy.
+//With flush set the data is written before deliver returns, instead of
+//waiting in a batch.
+func (c *Client) deliver(ctx context.Context, addr string, data []byte, flush bool) error {
+	for attempt := 0; ; attempt++ {
+		var err error
+		if addr == "" && len(c.destinations) > 0 {
+			err = c.failover(ctx, data, flush)
+		} else {
+			err = c.syncSendData(ctx, addr, data, flush)
+		}
+		if err == nil || attempt+1 >= c.retry.Attempts || ctx.Err() != nil {
+			return err
+		}
+
+		timer := time.NewTimer(c.retry.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) syncSendData(ctx context.Context, addr string, data []byte, flush bool) error {
+	addr = c.address(addr)
+	switch c.transport {
+	case TransportTCP, TransportTLS:
+		return c.connection(addr).send(ctx, frameOctetCounting(data), flush)
+	case TransportUnix:
+		return c.connection(addr).send(ctx, data, flush)
+	case TransportRELP:
+		return c.relpSession(addr).send(ctx, data)
+	default:
+		return c.datagramSocket(addr).send(ctx, data)
 	}
-	return nil
 }
 
-func (c *Client) asyncSendData(addr string, data []byte) {
-	c.wg.Add(1)
+func (c *Client) asyncSendData(ctx context.Context, addr string, data []byte) {
+	c.mutex.Lock()
+	if c.sending == 0 {
+		c.idle = nil
+	}
+	c.sending++
+	c.mutex.Unlock()
+
 	go func(a string, d []byte) {
-		defer c.wg.Done()
-		c.setAsyncError(c.syncSendData(a, d))
+		c.setAsyncError(a, d, c.deliver(ctx, a, d, false))
+
+		c.mutex.Lock()
+		c.sending--
+		if c.sending == 0 && c.idle != nil {
+			close(c.idle)
+		}
+		c.mutex.Unlock()
 	}(addr, data)
 }
 
-func (c *Client) setAsyncError(err error) {
+//setAsyncError keeps the result of an asynchronous send, and reports it to
+//the error handler if it failed
+func (c *Client) setAsyncError(addr string, data []byte, err error) {
 	c.mutex.Lock()
-	defer c.mutex.Unlock()
 	c.asyncError = err
+	c.mutex.Unlock()
+
+	if err != nil && c.errorHandler != nil {
+		c.errorHandler(&SendError{Address: addr, Data: data, Err: err})
+	}
+}
+
+//deliverQueue sends the messages in the disk queue in order until the queue
+//is closed. A message is only removed from the queue once it has been sent,
+//or sending it failed in a way that can't succeed later.
+func (c *Client) deliverQueue() {
+	for {
+		addr, data, err := c.queue.next()
+		if err == nil {
+			//the message is removed from the queue once deliver returns, so it
+			//can't wait in a batch
+			err = c.deliver(context.Background(), addr, data, true)
+			if err == nil || permanentError(err) {
+				//a message that can never be sent is dropped, so it doesn't hold
+				//up the messages after it
+				if ackErr := c.queue.ack(); ackErr != nil {
+					err = ackErr
+				}
+			}
+		}
+		if err == ErrQueueClosed {
+			return
+		}
+		c.setAsyncError(addr, data, err)
+		if err == nil || permanentError(err) {
+			continue
+		}
+
+		select {
+		case <-c.queue.done:
+			return
+		case <-time.After(queueRetryInterval):
+		}
+	}
+}
+
+//permanentError reports if sending again can't fix the error, such as a
+//message too large for a datagram, an invalid address, or a damaged message
+//in the disk queue
+func permanentError(err error) bool {
+	var addrErr *net.AddrError
+	var networkErr net.UnknownNetworkError
+	var invalidErr net.InvalidAddrError
+	return errors.Is(err, syscall.EMSGSIZE) || errors.Is(err, ErrCorruptRecord) ||
+		errors.As(err, &addrErr) || errors.As(err, &networkErr) || errors.As(err, &invalidErr)
+}
+
+//connection returns the persistent connection for the address, creating it
+//if this is the first message sent there
+func (c *Client) connection(addr string) *connection {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+
+	result, found := c.connections[addr]
+	if !found {
+		result = newConnection(func(ctx context.Context) (net.Conn, error) {
+			return c.dial(ctx, addr)
+		})
+		if c.batchSize > 0 && c.transport != TransportUnix {
+			result.batching(c.batchSize, c.batchInterval, func(data []byte, err error) {
+				c.setAsyncError(addr, data, err)
+			})
+		}
+		c.connections[addr] = result
+	}
+	return result
+}
+
+//datagramSocket returns the UDP socket for the address, creating it if this is
+//the first message sent there. Sockets to other addresses that haven't been
+//used for datagramIdleTTLs DNS cache TTLs, and at least minDatagramIdle, are
+//closed, so sending to many addresses over time doesn't keep a socket open for
+//each.
+func (c *Client) datagramSocket(addr string) *datagramSocket {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+
+	now := time.Now()
+	idle := max(datagramIdleTTLs*c.dnsCacheTTL, minDatagramIdle)
+	if now.Sub(c.datagramSweep) >= idle {
+		c.datagramSweep = now
+		for other, socket := range c.datagrams {
+			if other != addr && now.Sub(socket.used) >= idle {
+				socket.shutdown()
+				delete(c.datagrams, other)
+			}
+		}
+	}
+
+	result, found := c.datagrams[addr]
+	if !found {
+		result = newDatagramSocket(addr, c.dnsCacheTTL)
+		c.datagrams[addr] = result
+	}
+	result.used = now
+	return result
+}
+
+//relpSession returns the RELP session for the address, creating it if this
+//is the first message sent there
+func (c *Client) relpSession(addr string) *relpSession {
+	c.connMutex.Lock()
+	defer c.connMutex.Unlock()
+
+	result, found := c.sessions[addr]
+	if !found {
+		result = newRELPSession(func(ctx context.Context) (net.Conn, error) {
+			return c.dial(ctx, addr)
+		})
+		c.sessions[addr] = result
+	}
+	return result
+}
+
+//dial opens a stream connection to the address with the client's transport
+func (c *Client) dial(ctx context.Context, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	switch c.transport {
+	case TransportTLS:
+		return (&tls.Dialer{NetDialer: dialer, Config: c.tlsConfig}).DialContext(ctx, "tcp", addr)
+	case TransportUnix:
+		return dialUnix(ctx, dialer, addr)
+	default:
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+}
+
+//address adds the default port of the transport to network addresses
+func (c *Client) address(addr string) string {
+	if c.transport == TransportUnix {
+		return addr
+	}
+	return withDefaultPort(addr, c.transport.defaultPort())
+}
+
+//withDefaultPort adds the port to the address if it doesn't already have one.
+//The address can be a hostname, IPv4 address, or IPv6 address with or without
+//brackets.
+func withDefaultPort(addr string, port int) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	return net.JoinHostPort(host, strconv.Itoa(port))
 }
@@ -0,0 +1,458 @@
+package mbsyslog_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/venutios/mbsyslog"
+)
+
+//readOctetCountedFrame reads a single RFC 6587 octet counted frame
+func readOctetCountedFrame(r *bufio.Reader) (string, error) {
+	length, err := r.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	count, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+	if err != nil {
+		return "", err
+	}
+	data := make([]byte, count)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func TestClient_TCP(t *testing.T) {
+	msgs := []string{
+		"<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - BOM'su root' failed for lonvick on /dev/pts/8",
+		"<165>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 myproc 8710 - - %% It's time to make the do-nuts.",
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer listener.Close()
+
+	//every connection reads a single frame then closes, so each message
+	//forces the client to reconnect
+	received := make(chan string, len(msgs))
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			frame, err := readOctetCountedFrame(bufio.NewReader(conn))
+			if err == nil {
+				received <- frame
+			}
+			conn.Close()
+		}
+	}()
+
+	client := mbsyslog.NewClient(true, mbsyslog.WithTransport(mbsyslog.TransportTCP))
+	defer client.Close()
+
+	for _, m := range msgs {
+		if err := client.SendData(listener.Addr().String(), []byte(m)); err != nil {
+			t.Fatalf("Client.SendData() error: %s", err)
+		}
+
+		select {
+		case got := <-received:
+			if got != m {
+				t.Errorf("Client.SendData() sent %q, want %q", got, m)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Client.SendData() message never received: %s", m)
+		}
+
+		//give the client time to notice the server closed the connection
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestClient_TCPPersistent(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan *bufio.Reader, 5)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			accepted <- bufio.NewReader(conn)
+		}
+	}()
+
+	client := mbsyslog.NewClient(true, mbsyslog.WithTransport(mbsyslog.TransportTCP))
+	defer client.Close()
+
+	for x := 0; x < 3; x++ {
+		if err := client.SendData(listener.Addr().String(), []byte("<151>message "+strconv.Itoa(x))); err != nil {
+			t.Fatalf("Client.SendData() error: %s", err)
+		}
+	}
+
+	var reader *bufio.Reader
+	select {
+	case reader = <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client never connected")
+	}
+
+	for x := 0; x < 3; x++ {
+		got, err := readOctetCountedFrame(reader)
+		if err != nil {
+			t.Fatalf("Failed to read frame: %s", err)
+		}
+		if want := "<151>message " + strconv.Itoa(x); got != want {
+			t.Errorf("Client.SendData() sent %q, want %q", got, want)
+		}
+	}
+
+	select {
+	case <-accepted:
+		t.Error("Client opened more than one connection")
+	default:
+	}
+}
+
+func TestClient_Log(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer conn.Close()
+
+	client := mbsyslog.NewClient(true,
+		mbsyslog.WithDestination(conn.LocalAddr().String()),
+		mbsyslog.WithFacility(mbsyslog.MessageFacilityLocal3),
+		mbsyslog.WithHostname("mymachine.example.com"),
+		mbsyslog.WithApplication("myapp"),
+		mbsyslog.WithProcessID(4321))
+
+	tests := []struct {
+		name     string
+		log      func(string) error
+		severity mbsyslog.MessageSeverity
+	}{
+		{"Emerg", client.Emerg, mbsyslog.MessageSeverityEmergency},
+		{"Alert", client.Alert, mbsyslog.MessageSeverityAlert},
+		{"Crit", client.Crit, mbsyslog.MessageSeverityCritical},
+		{"Err", client.Err, mbsyslog.MessageSeverityError},
+		{"Warning", client.Warning, mbsyslog.MessageSeverityWarning},
+		{"Notice", client.Notice, mbsyslog.MessageSeverityNotice},
+		{"Info", client.Info, mbsyslog.MessageSeverityInformational},
+		{"Debug", client.Debug, mbsyslog.MessageSeverityDebug},
+	}
+	buffer := make([]byte, 8192)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := time.Now().Add(-time.Second)
+			if err := tt.log("The quick brown fox jumps over the lazy dog"); err != nil {
+				t.Fatalf("Client.%s() error: %s", tt.name, err)
+			}
+
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			count, _, err := conn.ReadFrom(buffer)
+			if err != nil {
+				t.Fatalf("Client.%s() message never received: %s", tt.name, err)
+			}
+
+			//the timestamp changes with every message, so check it separately
+			fields := strings.SplitN(string(buffer[:count]), " ", 3)
+			if len(fields) != 3 {
+				t.Fatalf("Client.%s() sent %q", tt.name, buffer[:count])
+			}
+			if want := "<" + strconv.Itoa(int(mbsyslog.MessageFacilityLocal3)*8+int(tt.severity)) + ">1"; fields[0] != want {
+				t.Errorf("Client.%s() sent priority %q, want %q", tt.name, fields[0], want)
+			}
+			if date, err := time.Parse(time.RFC3339Nano, fields[1]); err != nil || date.Before(before) {
+				t.Errorf("Client.%s() sent timestamp %q, want after %s", tt.name, fields[1], before)
+			}
+			if want := "mymachine.example.com myapp 4321 - - \xEF\xBB\xBFThe quick brown fox jumps over the lazy dog"; fields[2] != want {
+				t.Errorf("Client.%s() sent %q, want %q", tt.name, fields[2], want)
+			}
+		})
+	}
+}
+
+func TestClient_Send(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer conn.Close()
+
+	m, err := mbsyslog.NewMessageBuilder().
+		Facility(mbsyslog.MessageFacilityAuth).
+		Severity(mbsyslog.MessageSeverityCritical).
+		Timestamp(time.Date(2003, time.October, 11, 22, 14, 15, 0, time.UTC)).
+		Hostname("mymachine.example.com").
+		Application("su").
+		ProcessID(1234).
+		Content("'su root' failed for lonvick on /dev/pts/8").
+		Build()
+	if err != nil {
+		t.Fatalf("MessageBuilder.Build() error: %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		options []mbsyslog.ClientOption
+		want    string
+		wantErr bool
+	}{
+		{"RFC5424", []mbsyslog.ClientOption{mbsyslog.WithDestination(conn.LocalAddr().String())}, "<34>1 2003-10-11T22:14:15Z mymachine.example.com su 1234 - - \xEF\xBB\xBF'su root' failed for lonvick on /dev/pts/8", false},
+		{"RFC3164", []mbsyslog.ClientOption{mbsyslog.WithDestination(conn.LocalAddr().String()), mbsyslog.WithMessageFormat(mbsyslog.MessageFormatRFC3164)}, "<34>Oct 11 22:14:15 mymachine.example.com su[1234]: 'su root' failed for lonvick on /dev/pts/8", false},
+		{"NoDestination", nil, "", true},
+	}
+	buffer := make([]byte, 8192)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := mbsyslog.NewClient(true, tt.options...)
+			err := client.Send(m)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Client.Send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			count, _, err := conn.ReadFrom(buffer)
+			if err != nil {
+				t.Fatalf("Client.Send() message never received: %s", err)
+			}
+			if got := string(buffer[:count]); got != tt.want {
+				t.Errorf("Client.Send() sent %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_Batching(t *testing.T) {
+	msgs := make([]string, 10)
+	for index := range msgs {
+		msgs[index] = "<34>1 - mymachine.example.com su - - - message " + strconv.Itoa(index)
+	}
+
+	tests := []struct {
+		name     string
+		size     int
+		interval time.Duration
+		waiting  int
+	}{
+		{"Flush", 1 << 16, time.Hour, 10},
+		{"Size", 200, time.Hour, 2},
+		{"Interval", 1 << 16, 20 * time.Millisecond, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, frames := frameListener(t, "127.0.0.1:0")
+			client := mbsyslog.NewClient(true,
+				mbsyslog.WithTransport(mbsyslog.TransportTCP),
+				mbsyslog.WithBatching(tt.size, tt.interval))
+			defer client.Close()
+
+			for _, msg := range msgs {
+				if err := client.SendData(addr, []byte(msg)); err != nil {
+					t.Fatalf("Client.SendData() error: %s", err)
+				}
+			}
+
+			receive := func(want []string) {
+				for _, msg := range want {
+					select {
+					case frame := <-frames:
+						if frame != msg {
+							t.Errorf("Received %q, want %q", frame, msg)
+						}
+					case <-time.After(5 * time.Second):
+						t.Fatalf("Message %q never received", msg)
+					}
+				}
+			}
+
+			//the last messages wait in the batch until it is flushed
+			sent := len(msgs) - tt.waiting
+			receive(msgs[:sent])
+			select {
+			case frame := <-frames:
+				t.Fatalf("Received %q before the batch was flushed", frame)
+			case <-time.After(50 * time.Millisecond):
+			}
+			if err := client.Flush(); err != nil {
+				t.Fatalf("Client.Flush() error: %s", err)
+			}
+			receive(msgs[sent:])
+		})
+	}
+}
+
+func BenchmarkClient_SendDataTCP(b *testing.B) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatalf("Failed to listen: %s", err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+
+	benchmarks := []struct {
+		name    string
+		options []mbsyslog.ClientOption
+	}{
+		{"Unbatched", nil},
+		{"Batched", []mbsyslog.ClientOption{mbsyslog.WithBatching(64<<10, 10*time.Millisecond)}},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			client := mbsyslog.NewClient(true, append([]mbsyslog.ClientOption{mbsyslog.WithTransport(mbsyslog.TransportTCP)}, bm.options...)...)
+			defer client.Close()
+
+			b.SetBytes(int64(len(benchmarkMessage)))
+			b.ResetTimer()
+			for index := 0; index < b.N; index++ {
+				if err := client.SendData(listener.Addr().String(), benchmarkMessage); err != nil {
+					b.Fatalf("Client.SendData() error: %s", err)
+				}
+			}
+			if err := client.Flush(); err != nil {
+				b.Fatalf("Client.Flush() error: %s", err)
+			}
+		})
+	}
+}
+
+func TestClient_RateLimit(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer conn.Close()
+
+	client := mbsyslog.NewClient(true,
+		mbsyslog.WithDestination(conn.LocalAddr().String()),
+		mbsyslog.WithRateLimit(mbsyslog.RateLimit{Rate: 0.001, Burst: 2}),
+		mbsyslog.WithSeverityRateLimit(mbsyslog.MessageSeverityDebug, mbsyslog.RateLimit{Rate: 0.001, Sample: 2}),
+		mbsyslog.WithSeverityRateLimit(mbsyslog.MessageSeverityError, mbsyslog.RateLimit{}),
+		mbsyslog.WithSuppressionReport(time.Hour))
+
+	tests := []struct {
+		name  string
+		log   func(string) error
+		count int
+		want  int
+	}{
+		{"Info", client.Info, 5, 2},
+		{"Err", client.Err, 3, 3},
+		{"Crit", client.Crit, 3, 3},
+		{"Debug", client.Debug, 5, 3},
+	}
+	buffer := make([]byte, 8192)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for index := 0; index < tt.count; index++ {
+				if err := tt.log(tt.name); err != nil {
+					t.Fatalf("Client.%s() error: %s", tt.name, err)
+				}
+			}
+			for index := 0; index < tt.want; index++ {
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				count, _, err := conn.ReadFrom(buffer)
+				if err != nil {
+					t.Fatalf("Client.%s() message never received: %s", tt.name, err)
+				}
+				if m := mbsyslog.NewMessage(nil, buffer[:count]); m.Content() != tt.name {
+					t.Errorf("Client.%s() sent %q", tt.name, buffer[:count])
+				}
+			}
+		})
+	}
+
+	//the suppressed messages not yet reported are reported as a warning on close
+	if err := client.Close(); err != nil {
+		t.Fatalf("Client.Close() error: %s", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	count, _, err := conn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatalf("Suppression report never received: %s", err)
+	}
+	m := mbsyslog.NewMessage(nil, buffer[:count])
+	if m.Severity() != mbsyslog.MessageSeverityWarning || m.Content() != "5 messages suppressed by the rate limit" {
+		t.Errorf("Client sent suppression report %q", buffer[:count])
+	}
+	if client.Suppressed() != 5 {
+		t.Errorf("Client.Suppressed() = %d, want 5", client.Suppressed())
+	}
+}
+
+func TestClient_SuppressionReport(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer conn.Close()
+
+	client := mbsyslog.NewClient(true,
+		mbsyslog.WithDestination(conn.LocalAddr().String()),
+		mbsyslog.WithRateLimit(mbsyslog.RateLimit{Rate: 0.001, Burst: 1}),
+		mbsyslog.WithSuppressionReport(50*time.Millisecond))
+	buffer := make([]byte, 8192)
+	read := func() string {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		count, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			t.Fatalf("Message never received: %s", err)
+		}
+		return mbsyslog.NewMessage(nil, buffer[:count]).Content()
+	}
+
+	//the report is sent once the interval has passed
+	client.Info("allowed")
+	client.Info("suppressed")
+	if got := read(); got != "allowed" {
+		t.Fatalf("Client sent %q, want allowed", got)
+	}
+	if got := read(); got != "1 messages suppressed by the rate limit" {
+		t.Errorf("Client sent suppression report %q", got)
+	}
+
+	//closing sends the pending report straight away, and stops the timer
+	client.Info("suppressed again")
+	if err := client.Close(); err != nil {
+		t.Fatalf("Client.Close() error: %s", err)
+	}
+	if got := read(); got != "1 messages suppressed by the rate limit" {
+		t.Errorf("Client sent suppression report %q on close", got)
+	}
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if count, _, err := conn.ReadFrom(buffer); err == nil {
+		t.Errorf("Client sent %q after it was closed", buffer[:count])
+	}
+}
@@ -0,0 +1,230 @@
+package mbsyslog
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+)
+
+//connection is a persistent stream connection to a single destination. The
+//connection is dialed on first use, and dialed again whenever the remote end
+//closes it or a write fails.
+//
+//With batching, messages are collected into one write that is made once the
+//batch reaches the batch size or the batch interval has passed.
+type connection struct {
+	dial          func(ctx context.Context) (net.Conn, error)
+	mutex         sync.Mutex
+	conn          net.Conn
+	closed        chan struct{}
+	batchSize     int
+	batchInterval time.Duration
+	batch         []byte
+	timer         *time.Timer
+	batchError    func(data []byte, err error)
+}
+
+func newConnection(dial func(ctx context.Context) (net.Conn, error)) *connection {
+	result := new(connection)
+	result.dial = dial
+	return result
+}
+
+//batching collects the messages sent into batches of the size, which are
+//written at least as often as the interval. Errors writing a batch that
+//wasn't flushed by a send are reported to the function.
+func (c *connection) batching(size int, interval time.Duration, batchError func(data []byte, err error)) {
+	c.batchSize = size
+	c.batchInterval = interval
+	c.batchError = batchError
+}
+
+//send writes the data to the destination, reconnecting once if the current
+//connection has dropped. The write is abandoned when the context is done.
+//
+//With batching the data is added to the batch instead, after making sure the
+//destination can be reached, unless flush is set to write the batch with the
+//data before returning. If writing the batch fails, the data is taken back out
+//for the caller to retry and the rest of the batch is kept to be written again
+//later.
+func (c *connection) send(ctx context.Context, data []byte, flush bool) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.batchSize <= 0 {
+		return c.sendNow(ctx, data)
+	}
+
+	if c.conn != nil && c.remoteClosed() {
+		c.reset()
+	}
+	if c.conn == nil {
+		if err := c.open(ctx); err != nil {
+			return err
+		}
+	}
+	c.batch = append(c.batch, data...)
+	if flush || len(c.batch) >= c.batchSize {
+		if err := c.flushBatch(ctx); err != nil {
+			c.batch = c.batch[:len(c.batch)-len(data)]
+			return err
+		}
+		return nil
+	}
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.batchInterval, c.flushLater)
+	}
+	return nil
+}
+
+//flush writes the batch waiting to be sent
+func (c *connection) flush(ctx context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.flushBatch(ctx)
+}
+
+//flushLater writes the batch once the batch interval has passed
+func (c *connection) flushLater() {
+	c.mutex.Lock()
+	data := append([]byte(nil), c.batch...)
+	c.timer = nil
+	err := c.flushBatch(context.Background())
+	c.mutex.Unlock()
+
+	if err != nil && c.batchError != nil {
+		c.batchError(data, err)
+	}
+}
+
+//flushBatch writes the batch. If writing it fails the batch is kept, and
+//written again once the batch interval has passed.
+func (c *connection) flushBatch(ctx context.Context) error {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if len(c.batch) == 0 {
+		return nil
+	}
+
+	if err := c.sendNow(ctx, c.batch); err != nil {
+		c.timer = time.AfterFunc(c.batchInterval, c.flushLater)
+		return err
+	}
+	c.batch = c.batch[:0]
+	return nil
+}
+
+//sendNow writes the data. If the current connection has dropped, or the write
+//fails, it reconnects and writes the data once more.
+func (c *connection) sendNow(ctx context.Context, data []byte) error {
+	//a write to a connection the remote end has closed can appear to succeed,
+	//so drop the connection if the reader has already seen it close
+	if c.conn != nil && c.remoteClosed() {
+		c.reset()
+	}
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if c.conn == nil {
+			if err = c.open(ctx); err != nil {
+				return err
+			}
+		}
+
+		err = c.write(ctx, data)
+		if err == nil {
+			return nil
+		}
+
+		//the connection is no longer usable, try again on a new one
+		c.reset()
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+//write writes the data to the current connection before the context is done
+func (c *connection) write(ctx context.Context, data []byte) error {
+	conn := c.conn
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetWriteDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetWriteDeadline(time.Unix(1, 0))
+	})
+
+	count, err := conn.Write(data)
+	if stop() {
+		conn.SetWriteDeadline(time.Time{})
+	} else {
+		//the deadline may be set at any moment, so the connection can't be reused
+		c.reset()
+	}
+
+	if err == nil && count != len(data) {
+		err = errors.New("Wrong number of bytes written")
+	}
+	return err
+}
+
+//close writes the batch waiting to be sent and shuts down the connection, a
+//later send will dial a new one. A batch that fails to write is dropped and
+//reported with the error.
+func (c *connection) close() error {
+	c.mutex.Lock()
+	err := c.flushBatch(context.Background())
+	data := append([]byte(nil), c.batch...)
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.batch = c.batch[:0]
+	c.reset()
+	c.mutex.Unlock()
+
+	if err != nil && c.batchError != nil {
+		c.batchError(data, err)
+	}
+	return err
+}
+
+func (c *connection) open(ctx context.Context) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.closed = make(chan struct{})
+
+	//syslog servers don't send data back, so the reader only exists to notice
+	//when the remote end closes the connection
+	go func(conn net.Conn, closed chan struct{}) {
+		io.Copy(ioutil.Discard, conn)
+		close(closed)
+	}(c.conn, c.closed)
+	return nil
+}
+
+func (c *connection) remoteClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *connection) reset() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
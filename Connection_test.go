@@ -0,0 +1,85 @@
+package mbsyslog
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConnection_BatchFailure(t *testing.T) {
+	//while failing, connections open but the remote end is gone, so writes fail
+	var failing atomic.Bool
+	received := make(chan string, 10)
+	c := newConnection(func(ctx context.Context) (net.Conn, error) {
+		local, remote := net.Pipe()
+		if failing.Load() {
+			remote.Close()
+			return local, nil
+		}
+		go func() {
+			buffer := make([]byte, 1024)
+			for {
+				count, err := remote.Read(buffer)
+				if err != nil {
+					return
+				}
+				received <- string(buffer[:count])
+			}
+		}()
+		return local, nil
+	})
+	reported := make(chan string, 10)
+	c.batching(10, time.Hour, func(data []byte, err error) {
+		reported <- string(data)
+	})
+
+	if err := c.send(context.Background(), []byte("first;"), false); err != nil {
+		t.Fatalf("connection.send() error: %s", err)
+	}
+	failing.Store(true)
+	c.reset()
+
+	//the send that fills the batch gets the error for its own message, and the
+	//message before it stays in the batch
+	if err := c.send(context.Background(), []byte("second;"), false); !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("connection.send() error = %v, want %v", err, io.ErrClosedPipe)
+	}
+	if err := c.flush(context.Background()); err == nil {
+		t.Fatal("connection.flush() succeeded while failing")
+	}
+
+	failing.Store(false)
+	if err := c.flush(context.Background()); err != nil {
+		t.Fatalf("connection.flush() error: %s", err)
+	}
+	select {
+	case got := <-received:
+		if got != "first;" {
+			t.Errorf("Received %q, want %q", got, "first;")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Batch never written after the failure")
+	}
+
+	//a batch close can't write is reported
+	if err := c.send(context.Background(), []byte("third;"), false); err != nil {
+		t.Fatalf("connection.send() error: %s", err)
+	}
+	failing.Store(true)
+	c.reset()
+	if err := c.close(); err == nil {
+		t.Fatal("connection.close() succeeded while failing")
+	}
+	select {
+	case got := <-reported:
+		if got != "third;" {
+			t.Errorf("Reported %q, want %q", got, "third;")
+		}
+	default:
+		t.Error("Batch dropped by close not reported")
+	}
+}
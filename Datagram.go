@@ -0,0 +1,143 @@
+package mbsyslog
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+//defaultDNSCacheTTL is how long a client uses the address a destination name
+//resolved to before resolving it again
+const defaultDNSCacheTTL = 30 * time.Second
+
+const (
+	//datagramIdleTTLs is how many DNS cache TTLs a UDP socket can go unused
+	//before the client closes it
+	datagramIdleTTLs = 4
+	//minDatagramIdle keeps the sockets of a short TTL open long enough for the
+	//sends that were given them to finish
+	minDatagramIdle = time.Second
+)
+
+//WithDNSCacheTTL sets how long the address of a UDP destination is cached.
+//Once it expires the name is resolved again with the next message, and the
+//socket is reconnected if the address changed. A socket unused for four TTLs,
+//and at least a second, is closed. The default is 30 seconds.
+func WithDNSCacheTTL(ttl time.Duration) ClientOption {
+	return func(c *Client) {
+		c.dnsCacheTTL = ttl
+	}
+}
+
+//datagramSocket is a connected UDP socket to a single destination, so
+//messages don't pay for a DNS lookup and a new socket each. The client sets
+//used under its connection mutex whenever it hands out the socket.
+type datagramSocket struct {
+	addr    string
+	ttl     time.Duration
+	mutex   sync.Mutex
+	conn    *net.UDPConn
+	expires time.Time
+	used    time.Time
+}
+
+func newDatagramSocket(addr string, ttl time.Duration) *datagramSocket {
+	result := new(datagramSocket)
+	result.addr = addr
+	result.ttl = ttl
+	return result
+}
+
+//send writes the data as one datagram. A connected socket reports errors
+//from earlier datagrams, such as the port being unreachable, on a later
+//write, so the write is tried once more on a new socket when it fails.
+func (d *datagramSocket) send(ctx context.Context, data []byte) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if d.conn == nil || !time.Now().Before(d.expires) {
+			if err = d.resolve(ctx); err != nil {
+				return err
+			}
+		}
+
+		if deadline, ok := ctx.Deadline(); ok {
+			d.conn.SetWriteDeadline(deadline)
+		} else {
+			d.conn.SetWriteDeadline(time.Time{})
+		}
+		var count int
+		count, err = d.conn.Write(data)
+		if err == nil && count != len(data) {
+			err = errors.New("Wrong number of bytes written")
+		}
+		if err == nil {
+			return nil
+		}
+
+		d.close()
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+//resolve looks up the destination, connecting a new socket if its address
+//changed. If the lookup fails the socket carries on with the old address.
+func (d *datagramSocket) resolve(ctx context.Context) error {
+	host, port, err := net.SplitHostPort(d.addr)
+	if err != nil {
+		return err
+	}
+	portNumber, err := net.DefaultResolver.LookupPort(ctx, "udp", port)
+	if err != nil {
+		return err
+	}
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err == nil && len(ips) == 0 {
+		err = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	if err != nil {
+		if d.conn != nil {
+			d.expires = time.Now().Add(d.ttl)
+			return nil
+		}
+		return err
+	}
+	d.expires = time.Now().Add(d.ttl)
+
+	remote := &net.UDPAddr{IP: ips[0].IP, Port: portNumber, Zone: ips[0].Zone}
+	if d.conn != nil {
+		if current, ok := d.conn.RemoteAddr().(*net.UDPAddr); ok && current.IP.Equal(remote.IP) && current.Port == remote.Port {
+			return nil
+		}
+		d.close()
+	}
+	conn, err := net.DialUDP("udp", nil, remote)
+	if err != nil {
+		return err
+	}
+	d.conn = conn
+	return nil
+}
+
+//close closes the socket, a later send will open a new one
+func (d *datagramSocket) close() {
+	if d.conn != nil {
+		d.conn.Close()
+		d.conn = nil
+	}
+}
+
+//shutdown closes the socket from outside a send
+func (d *datagramSocket) shutdown() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.close()
+}
@@ -0,0 +1,197 @@
+package mbsyslog_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/venutios/mbsyslog"
+)
+
+func TestClient_UDPSocket(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []mbsyslog.ClientOption
+	}{
+		{"Cached", nil},
+		{"ResolvedEveryMessage", []mbsyslog.ClientOption{mbsyslog.WithDNSCacheTTL(0)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Failed to listen: %s", err)
+			}
+			defer conn.Close()
+
+			client := mbsyslog.NewClient(true, tt.options...)
+			defer client.Close()
+
+			//every message comes from the same socket
+			var source string
+			buffer := make([]byte, 8192)
+			for index := 0; index < 3; index++ {
+				if err := client.SendData(conn.LocalAddr().String(), []byte("<34>1 - - - - - - reused")); err != nil {
+					t.Fatalf("Client.SendData() error: %s", err)
+				}
+				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				_, addr, err := conn.ReadFrom(buffer)
+				if err != nil {
+					t.Fatalf("Client.SendData() message never received: %s", err)
+				}
+				if source != "" && addr.String() != source {
+					t.Errorf("Client.SendData() sent from %s, want %s", addr, source)
+				}
+				source = addr.String()
+			}
+		})
+	}
+}
+
+//TestClient_UDPIdle checks a socket that hasn't been used for a while is
+//closed, and a new one opened for the next message
+func TestClient_UDPIdle(t *testing.T) {
+	var addrs []string
+	var conns []net.PacketConn
+	for range 2 {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %s", err)
+		}
+		defer conn.Close()
+		addrs = append(addrs, conn.LocalAddr().String())
+		conns = append(conns, conn)
+	}
+
+	client := mbsyslog.NewClient(true, mbsyslog.WithDNSCacheTTL(10*time.Millisecond))
+	defer client.Close()
+	buffer := make([]byte, 8192)
+	send := func(index int) string {
+		t.Helper()
+		if err := client.SendData(addrs[index], []byte("<34>1 - - - - - - idle")); err != nil {
+			t.Fatalf("Client.SendData() error: %s", err)
+		}
+		conns[index].SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, addr, err := conns[index].ReadFrom(buffer)
+		if err != nil {
+			t.Fatalf("Client.SendData() message never received: %s", err)
+		}
+		return addr.String()
+	}
+
+	first := send(0)
+	time.Sleep(1100 * time.Millisecond)
+	//sending elsewhere closes the idle socket
+	send(1)
+	if again := send(0); again == first {
+		t.Errorf("Client.SendData() sent from %s again after the socket was idle", again)
+	}
+}
+
+//TestClient_UDPUnreachable checks a message isn't lost to the error the
+//socket reports for an earlier message to a closed port
+func TestClient_UDPUnreachable(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	addr := conn.LocalAddr().String()
+	conn.Close()
+
+	client := mbsyslog.NewClient(true)
+	defer client.Close()
+	client.SendData(addr, []byte("<34>1 - - - - - - lost"))
+	time.Sleep(50 * time.Millisecond)
+
+	conn, err = net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Skipf("Failed to listen on %s again: %s", addr, err)
+	}
+	defer conn.Close()
+	if err := client.SendData(addr, []byte("<34>1 - - - - - - received")); err != nil {
+		t.Fatalf("Client.SendData() error: %s", err)
+	}
+	buffer := make([]byte, 8192)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	count, _, err := conn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatalf("Client.SendData() message never received: %s", err)
+	}
+	if got := string(buffer[:count]); got != "<34>1 - - - - - - received" {
+		t.Errorf("Client.SendData() sent %q", got)
+	}
+}
+
+//udpSink listens on a local UDP port, discarding everything received
+func udpSink(b *testing.B) string {
+	b.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatalf("Failed to listen: %s", err)
+	}
+	b.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buffer := make([]byte, 65536)
+		for {
+			if _, _, err := conn.ReadFrom(buffer); err != nil {
+				return
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+var benchmarkMessage = []byte("<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - 'su root' failed for lonvick on /dev/pts/8")
+
+func BenchmarkClient_SendDataUDP(b *testing.B) {
+	addr := udpSink(b)
+	client := mbsyslog.NewClient(true)
+	defer client.Close()
+
+	b.ResetTimer()
+	for index := 0; index < b.N; index++ {
+		if err := client.SendData(addr, benchmarkMessage); err != nil {
+			b.Fatalf("Client.SendData() error: %s", err)
+		}
+	}
+}
+
+//BenchmarkUDP_DialPerMessage is how the client used to send, resolving the
+//address and opening a socket for every message
+func BenchmarkUDP_DialPerMessage(b *testing.B) {
+	addr := udpSink(b)
+
+	b.ResetTimer()
+	for index := 0; index < b.N; index++ {
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			b.Fatalf("Failed to resolve: %s", err)
+		}
+		conn, err := net.DialUDP("udp", nil, udpAddr)
+		if err != nil {
+			b.Fatalf("Failed to dial: %s", err)
+		}
+		if _, err := conn.Write(benchmarkMessage); err != nil {
+			b.Fatalf("Failed to write: %s", err)
+		}
+		conn.Close()
+	}
+}
+
+//BenchmarkUDP_OpenSocket writes on a socket that is already open, the best
+//the client can do
+func BenchmarkUDP_OpenSocket(b *testing.B) {
+	conn, err := net.Dial("udp", udpSink(b))
+	if err != nil {
+		b.Fatalf("Failed to dial: %s", err)
+	}
+	defer conn.Close()
+
+	b.ResetTimer()
+	for index := 0; index < b.N; index++ {
+		if _, err := conn.Write(benchmarkMessage); err != nil {
+			b.Fatalf("Failed to write: %s", err)
+		}
+	}
+}
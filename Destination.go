@@ -0,0 +1,239 @@
+package mbsyslog
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+//defaultHealthInterval is how often a destination that failed is checked
+//before the client fails back to it
+const defaultHealthInterval = 30 * time.Second
+
+//Destination is a server the client sends messages to, in the same form as
+//the SendData address
+type Destination struct {
+	Address string
+	//Priority orders the destinations, lower values are preferred. Messages go
+	//to the most preferred destination that is healthy.
+	Priority int
+}
+
+//DestinationStats reports the health of a destination and how sending to it
+//went
+type DestinationStats struct {
+	Destination
+	//Healthy is false from when sending failed until a health check succeeds
+	Healthy bool
+	//Since is when the destination last became healthy or unhealthy
+	Since time.Time
+	//Sent is the number of messages sent to the destination
+	Sent uint64
+	//Failures is the number of messages that failed to send
+	Failures uint64
+	//LastError is the error of the last failure or health check that failed
+	LastError error
+}
+
+//WithDestinations sets the destinations Send and the logging methods deliver
+//messages to. When a destination fails it is skipped until a health check
+//succeeds, and messages fail over to the next destination by priority.
+func WithDestinations(destinations ...Destination) ClientOption {
+	return func(c *Client) {
+		c.destinations = make([]*destination, len(destinations))
+		for index, d := range destinations {
+			c.destinations[index] = newDestination(d)
+		}
+		sort.SliceStable(c.destinations, func(i, j int) bool {
+			return c.destinations[i].Priority < c.destinations[j].Priority
+		})
+	}
+}
+
+//WithHealthCheck sets how often a failed destination is checked, and the
+//check to run. A nil check opens a connection to the destination, or for UDP
+//resolves its address. Checks only run while messages are being sent.
+//
+//UDP can't tell if a collector is running, so with the nil check a UDP
+//destination is healthy again as soon as its address resolves. Set a check
+//that asks the collector some other way to fail back only once it is up.
+func WithHealthCheck(interval time.Duration, check func(ctx context.Context, addr string) error) ClientOption {
+	return func(c *Client) {
+		if interval > 0 {
+			c.healthInterval = interval
+		}
+		c.healthCheck = check
+	}
+}
+
+//DestinationStats returns the health and statistics of the destinations, in
+//order of priority
+func (c *Client) DestinationStats() []DestinationStats {
+	result := make([]DestinationStats, len(c.destinations))
+	for index, d := range c.destinations {
+		result[index] = d.stats()
+	}
+	return result
+}
+
+//destination keeps the health of a destination
+type destination struct {
+	Destination
+	mutex     sync.Mutex
+	healthy   bool
+	checking  bool
+	lastCheck time.Time
+	since     time.Time
+	sent      uint64
+	failures  uint64
+	lastError error
+}
+
+func newDestination(d Destination) *destination {
+	result := new(destination)
+	result.Destination = d
+	result.healthy = true
+	result.since = time.Now()
+	return result
+}
+
+//isHealthy reports if the destination hasn't failed since it was last checked
+func (d *destination) isHealthy() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.healthy
+}
+
+//record counts the result of sending a message, marking the destination
+//unhealthy if it failed
+func (d *destination) record(err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if err == nil {
+		d.sent++
+		return
+	}
+	d.failures++
+	d.lastError = err
+	if d.healthy {
+		d.healthy = false
+		d.since = time.Now()
+		d.lastCheck = d.since
+	}
+}
+
+//startCheck reports if an unhealthy destination is due a health check, and
+//if so marks it as being checked
+func (d *destination) startCheck(interval time.Duration) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.healthy || d.checking || time.Since(d.lastCheck) < interval {
+		return false
+	}
+	d.checking = true
+	return true
+}
+
+//finishCheck records the result of a health check
+func (d *destination) finishCheck(err error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.checking = false
+	d.lastCheck = time.Now()
+	if err != nil {
+		d.lastError = err
+		return
+	}
+	d.healthy = true
+	d.since = d.lastCheck
+}
+
+func (d *destination) stats() DestinationStats {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return DestinationStats{
+		Destination: d.Destination,
+		Healthy:     d.healthy,
+		Since:       d.since,
+		Sent:        d.sent,
+		Failures:    d.failures,
+		LastError:   d.lastError,
+	}
+}
+
+//failover sends the data to the healthy destinations in order of priority
+//until one succeeds. The unhealthy destinations are tried last, and are
+//checked in the background so the client can fail back to them.
+func (c *Client) failover(ctx context.Context, data []byte, flush bool) error {
+	healthy := make([]*destination, 0, len(c.destinations))
+	var unhealthy []*destination
+	for _, d := range c.destinations {
+		if d.isHealthy() {
+			healthy = append(healthy, d)
+		} else {
+			unhealthy = append(unhealthy, d)
+			c.checkHealth(d)
+		}
+	}
+
+	var err error
+	for _, d := range append(healthy, unhealthy...) {
+		err = c.syncSendData(ctx, d.Address, data, flush)
+		if err != nil && (ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+			//the caller gave up, which says nothing about the destination
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		d.record(err)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+//checkHealth starts a health check of the destination if one is due
+func (c *Client) checkHealth(d *destination) {
+	if !d.startCheck(c.healthInterval) {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+		defer cancel()
+
+		check := c.healthCheck
+		if check == nil {
+			check = c.defaultHealthCheck
+		}
+		d.finishCheck(check(ctx, d.Address))
+	}()
+}
+
+//defaultHealthCheck opens a connection to the destination. UDP has no
+//connection, so only the address is resolved, and a collector that isn't
+//running still passes.
+func (c *Client) defaultHealthCheck(ctx context.Context, addr string) error {
+	if c.transport == TransportUDP {
+		host, _, err := net.SplitHostPort(c.address(addr))
+		if err == nil {
+			_, err = net.DefaultResolver.LookupHost(ctx, host)
+		}
+		return err
+	}
+
+	conn, err := c.dial(ctx, c.address(addr))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
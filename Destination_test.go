@@ -0,0 +1,225 @@
+package mbsyslog_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/venutios/mbsyslog"
+)
+
+//frameListener accepts TCP connections on the address and passes on the
+//octet counted frames received. It returns the address listened on.
+func frameListener(t *testing.T, addr string) (string, <-chan string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	frames := make(chan string, 100)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					frame, err := readOctetCountedFrame(reader)
+					if err != nil {
+						return
+					}
+					frames <- frame
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String(), frames
+}
+
+func TestClient_Failover(t *testing.T) {
+	primary := unreachableAddress(t)
+	secondary, secondaryFrames := frameListener(t, "127.0.0.1:0")
+
+	client := mbsyslog.NewClient(true,
+		mbsyslog.WithTransport(mbsyslog.TransportTCP),
+		mbsyslog.WithDestinations(
+			mbsyslog.Destination{Address: secondary, Priority: 2},
+			mbsyslog.Destination{Address: primary, Priority: 1}),
+		mbsyslog.WithHealthCheck(10*time.Millisecond, nil))
+	defer client.Close()
+
+	//the primary is down, so messages fail over to the secondary
+	if err := client.Info("failed over"); err != nil {
+		t.Fatalf("Client.Info() error: %s", err)
+	}
+	select {
+	case <-secondaryFrames:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Secondary never received the message")
+	}
+	stats := client.DestinationStats()
+	if len(stats) != 2 || stats[0].Address != primary || stats[0].Healthy || stats[0].Failures != 1 || stats[0].LastError == nil {
+		t.Errorf("Client.DestinationStats() primary = %+v", stats[0])
+	}
+	if len(stats) != 2 || stats[1].Address != secondary || !stats[1].Healthy || stats[1].Sent != 1 {
+		t.Errorf("Client.DestinationStats() secondary = %+v", stats[1])
+	}
+
+	//once the primary is back, a health check fails back to it
+	_, primaryFrames := frameListener(t, primary)
+	deadline := time.Now().Add(5 * time.Second)
+	for index := 0; ; index++ {
+		if time.Now().After(deadline) {
+			t.Fatalf("Client never failed back to the primary: %+v", client.DestinationStats())
+		}
+		if err := client.Info("message " + strconv.Itoa(index)); err != nil {
+			t.Fatalf("Client.Info() error: %s", err)
+		}
+		select {
+		case <-primaryFrames:
+		case <-secondaryFrames:
+			time.Sleep(10 * time.Millisecond)
+			continue
+		case <-time.After(5 * time.Second):
+			t.Fatal("Message never received")
+		}
+		break
+	}
+	if stats := client.DestinationStats(); !stats[0].Healthy || stats[0].Sent != 1 {
+		t.Errorf("Client.DestinationStats() primary = %+v", stats[0])
+	}
+}
+
+func TestClient_Retry(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  mbsyslog.RetryPolicy
+		timeout time.Duration
+		min     time.Duration
+		max     time.Duration
+		wantErr error
+	}{
+		{"Once", mbsyslog.RetryPolicy{}, time.Minute, 0, time.Second, nil},
+		{"Backoff", mbsyslog.RetryPolicy{Attempts: 3, InitialBackoff: 20 * time.Millisecond}, time.Minute, 60 * time.Millisecond, time.Second, nil},
+		{"Deadline", mbsyslog.RetryPolicy{Attempts: 3, InitialBackoff: time.Minute}, 50 * time.Millisecond, 50 * time.Millisecond, time.Second, context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := mbsyslog.NewClient(true,
+				mbsyslog.WithTransport(mbsyslog.TransportTCP),
+				mbsyslog.WithDestination(unreachableAddress(t)),
+				mbsyslog.WithRetry(tt.policy))
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := client.SendContext(ctx, mbsyslog.NewMessage(nil, []byte("<34>1 - - - - - - retried")))
+			elapsed := time.Since(start)
+			if err == nil {
+				t.Fatal("Client.SendContext() error = nil, want an error")
+			}
+			if tt.wantErr != nil && ctx.Err() != tt.wantErr {
+				t.Errorf("Client.SendContext() context error = %v, want %v", ctx.Err(), tt.wantErr)
+			}
+			if elapsed < tt.min || elapsed > tt.max {
+				t.Errorf("Client.SendContext() took %s, want between %s and %s", elapsed, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestClient_FailoverCanceled(t *testing.T) {
+	primary, _ := frameListener(t, "127.0.0.1:0")
+	secondary, _ := frameListener(t, "127.0.0.1:0")
+	client := mbsyslog.NewClient(true,
+		mbsyslog.WithTransport(mbsyslog.TransportTCP),
+		mbsyslog.WithDestinations(
+			mbsyslog.Destination{Address: primary, Priority: 1},
+			mbsyslog.Destination{Address: secondary, Priority: 2}))
+	defer client.Close()
+
+	//a caller giving up says nothing about the destination, so it stays healthy
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	m, err := mbsyslog.NewMessageBuilder().Content("canceled").Build()
+	if err != nil {
+		t.Fatalf("MessageBuilder.Build() error: %s", err)
+	}
+	if err := client.SendContext(ctx, m); err != context.Canceled {
+		t.Errorf("Client.SendContext() error = %v, want %v", err, context.Canceled)
+	}
+	for _, stats := range client.DestinationStats() {
+		if !stats.Healthy || stats.Failures != 0 {
+			t.Errorf("Client.DestinationStats() = %+v, want healthy", stats)
+		}
+	}
+}
+
+func TestClient_ErrorHandler(t *testing.T) {
+	failures := make(chan *mbsyslog.SendError, 5)
+	client := mbsyslog.NewClient(false,
+		mbsyslog.WithTransport(mbsyslog.TransportTCP),
+		mbsyslog.WithErrorHandler(func(err *mbsyslog.SendError) {
+			failures <- err
+		}))
+
+	addr := unreachableAddress(t)
+	msgs := []string{"<34>1 - - - - - - first", "<34>1 - - - - - - second"}
+	for _, msg := range msgs {
+		if err := client.SendData(addr, []byte(msg)); err != nil {
+			t.Fatalf("Client.SendData() error: %s", err)
+		}
+	}
+	if err := client.WaitContext(context.Background()); err != nil {
+		t.Fatalf("Client.WaitContext() error: %s", err)
+	}
+
+	//every failed message is reported, not only the last
+	got := make(map[string]bool)
+	for range msgs {
+		select {
+		case err := <-failures:
+			var sendErr *mbsyslog.SendError
+			if !errors.As(error(err), &sendErr) || err.Address != addr || err.Err == nil {
+				t.Errorf("SendError = %v", err)
+			}
+			got[string(err.Data)] = true
+		default:
+			t.Fatal("Error handler not called for every message")
+		}
+	}
+	for _, msg := range msgs {
+		if !got[msg] {
+			t.Errorf("Error handler not called for %q", msg)
+		}
+	}
+}
+
+func TestClient_WaitContext(t *testing.T) {
+	queue, err := mbsyslog.OpenDiskQueue(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenDiskQueue() error: %s", err)
+	}
+	defer queue.Close()
+
+	//the destination never comes up, so Wait would never return
+	client := mbsyslog.NewClient(false, mbsyslog.WithTransport(mbsyslog.TransportTCP), mbsyslog.WithDiskQueue(queue))
+	if err := client.SendData(unreachableAddress(t), []byte("<34>1 - - - - - - stuck")); err != nil {
+		t.Fatalf("Client.SendData() error: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := client.WaitContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Client.WaitContext() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
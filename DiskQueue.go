@@ -0,0 +1,655 @@
+package mbsyslog
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//ErrQueueFull is returned when a message would grow a disk queue past its
+//maximum size
+var ErrQueueFull = errors.New("Disk queue full")
+
+//ErrQueueClosed is returned when a message is sent through a closed disk queue
+var ErrQueueClosed = errors.New("Disk queue closed")
+
+//ErrCorruptRecord is reported when a message in a disk queue can't be read
+//back. The damaged data is moved to a file named after the segment with the
+//.corrupt extension, and delivery carries on with the next message.
+var ErrCorruptRecord = errors.New("Corrupt disk queue record")
+
+const (
+	//segmentSuffix is the extension of the segment files in the directory
+	segmentSuffix = ".seg"
+	//corruptSuffix is added to the segment name for the file holding the
+	//damaged data skipped in it
+	corruptSuffix = ".corrupt"
+	//cursorFile records the segment and offset of the oldest message that
+	//hasn't been delivered
+	cursorFile = "cursor"
+	//recordHeaderSize is the length and CRC-32 before every record payload
+	recordHeaderSize = 8
+	//maxRecordSize limits the payload of a record, so a corrupt length can't
+	//cause a huge allocation
+	maxRecordSize = 1 << 24
+)
+
+//DiskQueue keeps messages waiting to be sent in segment files in a directory,
+//so they survive the destination being unreachable and the process
+//restarting. Messages are delivered in the order they were sent, at least
+//once: a message delivered just before a crash may be delivered again.
+//
+//A directory must only be used by one queue at a time.
+type DiskQueue struct {
+	dir          string
+	syncPolicy   SyncPolicy
+	syncInterval time.Duration
+	segmentSize  int64
+	maxSize      int64
+	mutex        *sync.Mutex
+	changed      *sync.Cond
+	closed       bool
+	done         chan struct{}
+	dirty        bool
+	segments     []uint64
+	writer       *os.File
+	writeOffset  int64
+	reader       *os.File
+	readSegment  uint64
+	readOffset   int64
+	pending      int64
+	cursor       *os.File
+	size         int64
+	count        int
+}
+
+//DiskQueueOption configures optional behaviour of a disk queue
+type DiskQueueOption func(*DiskQueue)
+
+//WithSyncPolicy sets how often the queue flushes messages to stable storage.
+//The default is SyncAlways.
+func WithSyncPolicy(policy SyncPolicy) DiskQueueOption {
+	return func(q *DiskQueue) {
+		q.syncPolicy = policy
+	}
+}
+
+//WithSyncInterval sets how often the SyncPeriodic policy flushes the queue.
+//The default is one second.
+func WithSyncInterval(interval time.Duration) DiskQueueOption {
+	return func(q *DiskQueue) {
+		if interval > 0 {
+			q.syncInterval = interval
+		}
+	}
+}
+
+//WithSegmentSize sets the size at which the queue starts a new segment file.
+//Segments are deleted once every message in them is delivered. The default is
+//16 MiB.
+func WithSegmentSize(size int64) DiskQueueOption {
+	return func(q *DiskQueue) {
+		if size > 0 {
+			q.segmentSize = size
+		}
+	}
+}
+
+//WithMaxDiskSize limits the size of the messages waiting in the queue. Sending
+//a message that doesn't fit returns ErrQueueFull rather than dropping it. The
+//default is 1 GiB, or 0 for no limit.
+func WithMaxDiskSize(size int64) DiskQueueOption {
+	return func(q *DiskQueue) {
+		q.maxSize = size
+	}
+}
+
+//OpenDiskQueue opens the queue stored in the directory, creating it if needed.
+//Messages left in the queue by an earlier process are delivered first. A
+//message half written when the process stopped is discarded, as it was never
+//accepted. Damaged messages are skipped when they are reached, see
+//ErrCorruptRecord.
+func OpenDiskQueue(dir string, options ...DiskQueueOption) (*DiskQueue, error) {
+	result := new(DiskQueue)
+	result.dir = dir
+	result.syncPolicy = SyncAlways
+	result.syncInterval = time.Second
+	result.segmentSize = 16 << 20
+	result.maxSize = 1 << 30
+	result.mutex = &sync.Mutex{}
+	result.changed = sync.NewCond(result.mutex)
+	result.done = make(chan struct{})
+
+	for _, option := range options {
+		option(result)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := result.load(); err != nil {
+		result.closeFiles()
+		return nil, err
+	}
+	if result.syncPolicy == SyncPeriodic {
+		go result.syncPeriodically()
+	}
+	return result, nil
+}
+
+//Len returns the number of messages waiting to be delivered
+func (q *DiskQueue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.count
+}
+
+//Size returns the size of the messages waiting to be delivered, as stored on
+//disk
+func (q *DiskQueue) Size() int64 {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.size
+}
+
+//Close flushes the queue and closes its files. Messages not yet delivered are
+//kept for the next time the directory is opened.
+func (q *DiskQueue) Close() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	close(q.done)
+	q.changed.Broadcast()
+
+	var err error
+	if q.syncPolicy != SyncNever {
+		err = q.sync()
+	}
+	if closeErr := q.closeFiles(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+//load finds the segments in the directory and the position of the oldest
+//message not yet delivered
+func (q *DiskQueue) load() error {
+	names, err := filepath.Glob(filepath.Join(q.dir, "*"+segmentSuffix))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		segment, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), segmentSuffix), 10, 64)
+		if err == nil {
+			q.segments = append(q.segments, segment)
+		}
+	}
+	sort.Slice(q.segments, func(i, j int) bool { return q.segments[i] < q.segments[j] })
+
+	q.cursor, err = os.OpenFile(filepath.Join(q.dir, cursorFile), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	var position [16]byte
+	if count, _ := q.cursor.ReadAt(position[:], 0); count == len(position) {
+		q.readSegment = binary.BigEndian.Uint64(position[:8])
+		q.readOffset = int64(binary.BigEndian.Uint64(position[8:]))
+	}
+
+	//segments before the cursor have been delivered
+	for len(q.segments) > 0 && q.segments[0] < q.readSegment {
+		if err := os.Remove(q.segmentPath(q.segments[0])); err != nil {
+			return err
+		}
+		q.segments = q.segments[1:]
+	}
+	if len(q.segments) == 0 {
+		q.segments = []uint64{q.readSegment + 1}
+		if err := q.createSegment(q.segments[0]); err != nil {
+			return err
+		}
+	}
+	if q.segments[0] != q.readSegment {
+		q.readSegment, q.readOffset = q.segments[0], 0
+	}
+
+	for index, segment := range q.segments {
+		start := int64(0)
+		if segment == q.readSegment {
+			start = q.readOffset
+		}
+		last := index == len(q.segments)-1
+		end, count, err := q.scan(segment, start, last)
+		if err != nil {
+			return err
+		}
+		if segment == q.readSegment && start > end {
+			//the cursor is past the end of the segment, so nothing in it is left
+			start, q.readOffset = end, end
+		}
+		q.size += end - start
+		q.count += count
+		if last {
+			q.writeOffset = end
+		}
+	}
+
+	q.writer, err = os.OpenFile(q.segmentPath(q.segments[len(q.segments)-1]), os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	q.reader, err = os.Open(q.segmentPath(q.readSegment))
+	return err
+}
+
+//scan checks the records of the segment from the offset, and returns where
+//they end and how many there are. Damaged data is stepped over and left to be
+//skipped when it is read, except that a record cut short at the end of the
+//last segment was being written when the process stopped, so it is removed.
+func (q *DiskQueue) scan(segment uint64, start int64, last bool) (int64, int, error) {
+	path := q.segmentPath(segment)
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	if start > info.Size() {
+		return info.Size(), 0, nil
+	}
+
+	offset, count := start, 0
+	for offset < info.Size() {
+		payload, err := readRecord(io.NewSectionReader(file, offset, info.Size()-offset))
+		if err == nil {
+			offset += recordHeaderSize + int64(len(payload))
+			count++
+			continue
+		}
+
+		next, err := findRecord(file, offset, info.Size())
+		if err != nil {
+			return 0, 0, err
+		}
+		if next == info.Size() && last {
+			return offset, count, os.Truncate(path, offset)
+		}
+		offset = next
+	}
+	return offset, count, nil
+}
+
+//put stores the message at the end of the queue
+func (q *DiskQueue) put(addr string, data []byte) error {
+	if len(addr) > math.MaxUint16 || 2+len(addr)+len(data) > maxRecordSize {
+		return errors.New("Message too large for the disk queue")
+	}
+	record := make([]byte, recordHeaderSize+2+len(addr)+len(data))
+	payload := record[recordHeaderSize:]
+	binary.BigEndian.PutUint16(payload, uint16(len(addr)))
+	copy(payload[2:], addr)
+	copy(payload[2+len(addr):], data)
+	binary.BigEndian.PutUint32(record, uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:], crc32.ChecksumIEEE(payload))
+	length := int64(len(record))
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		return ErrQueueClosed
+	}
+	if q.maxSize > 0 && q.size+length > q.maxSize {
+		return ErrQueueFull
+	}
+	if q.writeOffset > 0 && q.writeOffset+length > q.segmentSize {
+		if err := q.nextWriteSegment(); err != nil {
+			return err
+		}
+	}
+
+	_, err := q.writer.WriteAt(record, q.writeOffset)
+	if err == nil && q.syncPolicy == SyncAlways {
+		err = q.writer.Sync()
+	}
+	if err != nil {
+		//don't leave part of a message the caller was told wasn't queued
+		q.writer.Truncate(q.writeOffset)
+		return err
+	}
+	q.dirty = true
+	q.writeOffset += length
+	q.size += length
+	q.count++
+	q.changed.Broadcast()
+	return nil
+}
+
+//next returns the oldest message in the queue, waiting for one if the queue
+//is empty. The message stays in the queue until it is acknowledged.
+func (q *DiskQueue) next() (string, []byte, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for q.count == 0 && !q.closed {
+		q.changed.Wait()
+	}
+	if q.closed {
+		return "", nil, ErrQueueClosed
+	}
+
+	for {
+		end, err := q.readEnd()
+		if err != nil {
+			return "", nil, err
+		}
+		payload, err := readRecord(io.NewSectionReader(q.reader, q.readOffset, end-q.readOffset))
+		if err == io.EOF {
+			if q.readSegment != q.segments[len(q.segments)-1] {
+				if err := q.nextReadSegment(); err != nil {
+					return "", nil, err
+				}
+				continue
+			}
+
+			//messages damaged after the queue was opened were counted, but
+			//were skipped, so the queue is empty
+			q.count, q.size = 0, 0
+			q.changed.Broadcast()
+			for q.count == 0 && !q.closed {
+				q.changed.Wait()
+			}
+			if q.closed {
+				return "", nil, ErrQueueClosed
+			}
+			continue
+		}
+
+		if err == nil && 2+int(binary.BigEndian.Uint16(payload)) > len(payload) {
+			err = errors.New("Invalid address length")
+		}
+		if err != nil {
+			return "", nil, q.skipCorrupt(end, err)
+		}
+		length := int(binary.BigEndian.Uint16(payload))
+		q.pending = recordHeaderSize + int64(len(payload))
+		return string(payload[2 : 2+length]), payload[2+length:], nil
+	}
+}
+
+//readEnd returns where the records of the segment being read end
+func (q *DiskQueue) readEnd() (int64, error) {
+	if q.readSegment == q.segments[len(q.segments)-1] {
+		return q.writeOffset, nil
+	}
+	info, err := q.reader.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+//skipCorrupt moves the damaged data at the read offset, up to the next record
+//that can be read, to the .corrupt file of the segment. The error describing
+//the damage is returned.
+func (q *DiskQueue) skipCorrupt(end int64, cause error) error {
+	offset := q.readOffset
+	next, err := findRecord(q.reader, offset, end)
+	if err != nil {
+		return err
+	}
+	damaged := make([]byte, next-offset)
+	if _, err := q.reader.ReadAt(damaged, offset); err != nil {
+		return err
+	}
+
+	path := q.segmentPath(q.readSegment)
+	file, err := os.OpenFile(path+corruptSuffix, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(damaged)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	q.readOffset = next
+	q.size -= next - offset
+	q.pending = 0
+	q.changed.Broadcast()
+	if err := q.writeCursor(); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w in %s at offset %d: %v", ErrCorruptRecord, path, offset, cause)
+}
+
+//ack removes the message returned by next from the queue
+func (q *DiskQueue) ack() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed || q.pending == 0 {
+		return nil
+	}
+	q.readOffset += q.pending
+	q.size -= q.pending
+	q.count--
+	q.pending = 0
+	q.changed.Broadcast()
+	return q.writeCursor()
+}
+
+//drained waits until every message in the queue has been delivered, or the
+//queue is closed. It gives up when the context is done, returning the context
+//error.
+func (q *DiskQueue) drained(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() {
+		q.mutex.Lock()
+		q.changed.Broadcast()
+		q.mutex.Unlock()
+	})
+	defer stop()
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for q.count > 0 && !q.closed {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		q.changed.Wait()
+	}
+	return nil
+}
+
+//nextWriteSegment starts a new segment for the messages that follow
+func (q *DiskQueue) nextWriteSegment() error {
+	if q.syncPolicy != SyncNever {
+		if err := q.writer.Sync(); err != nil {
+			return err
+		}
+	}
+
+	segment := q.segments[len(q.segments)-1] + 1
+	if err := q.createSegment(segment); err != nil {
+		return err
+	}
+	writer, err := os.OpenFile(q.segmentPath(segment), os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	q.writer.Close()
+	q.writer = writer
+	q.writeOffset = 0
+	q.segments = append(q.segments, segment)
+	return nil
+}
+
+//nextReadSegment deletes the segment that has been read to the end and
+//moves on to the next one
+func (q *DiskQueue) nextReadSegment() error {
+	reader, err := os.Open(q.segmentPath(q.segments[1]))
+	if err != nil {
+		return err
+	}
+	q.reader.Close()
+	q.reader = reader
+	if err := os.Remove(q.segmentPath(q.readSegment)); err != nil {
+		return err
+	}
+	q.segments = q.segments[1:]
+	q.readSegment, q.readOffset = q.segments[0], 0
+	return q.writeCursor()
+}
+
+//createSegment creates an empty segment file, making sure it is still there
+//after a crash when every message is flushed
+func (q *DiskQueue) createSegment(segment uint64) error {
+	file, err := os.OpenFile(q.segmentPath(segment), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	file.Close()
+
+	if q.syncPolicy != SyncAlways {
+		return nil
+	}
+	dir, err := os.Open(q.dir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+//writeCursor records the position of the oldest message not yet delivered
+func (q *DiskQueue) writeCursor() error {
+	var position [16]byte
+	binary.BigEndian.PutUint64(position[:8], q.readSegment)
+	binary.BigEndian.PutUint64(position[8:], uint64(q.readOffset))
+	if _, err := q.cursor.WriteAt(position[:], 0); err != nil {
+		return err
+	}
+	q.dirty = true
+	if q.syncPolicy == SyncAlways {
+		return q.cursor.Sync()
+	}
+	return nil
+}
+
+func (q *DiskQueue) syncPeriodically() {
+	ticker := time.NewTicker(q.syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.done:
+			return
+		case <-ticker.C:
+			q.mutex.Lock()
+			if !q.closed {
+				q.sync()
+			}
+			q.mutex.Unlock()
+		}
+	}
+}
+
+//sync flushes the segment being written and the cursor if they changed
+func (q *DiskQueue) sync() error {
+	if !q.dirty {
+		return nil
+	}
+	if err := q.writer.Sync(); err != nil {
+		return err
+	}
+	if err := q.cursor.Sync(); err != nil {
+		return err
+	}
+	q.dirty = false
+	return nil
+}
+
+func (q *DiskQueue) closeFiles() error {
+	var result error
+	for _, file := range []*os.File{q.writer, q.reader, q.cursor} {
+		if file == nil {
+			continue
+		}
+		if err := file.Close(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+func (q *DiskQueue) segmentPath(segment uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", segment, segmentSuffix))
+}
+
+//findRecord returns the offset of the first record after the damaged one at
+//the offset, or the end if there isn't one before it
+func findRecord(r io.ReaderAt, offset int64, end int64) (int64, error) {
+	data := make([]byte, end-offset)
+	if _, err := r.ReadAt(data, offset); err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	for index := 1; index+recordHeaderSize <= len(data); index++ {
+		length := binary.BigEndian.Uint32(data[index:])
+		if length < 2 || length > maxRecordSize || int64(length) > int64(len(data)-index-recordHeaderSize) {
+			continue
+		}
+		payload := data[index+recordHeaderSize : index+recordHeaderSize+int(length)]
+		if crc32.ChecksumIEEE(payload) == binary.BigEndian.Uint32(data[index+4:]) {
+			return offset + int64(index), nil
+		}
+	}
+	return end, nil
+}
+
+//readRecord reads the record at the start of the reader, checking its
+//checksum. It returns io.EOF when there are no more records.
+func readRecord(r io.Reader) ([]byte, error) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[:4])
+	if length < 2 || length > maxRecordSize {
+		return nil, fmt.Errorf("Invalid record length %d", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, errors.New("Record checksum mismatch")
+	}
+	return payload, nil
+}
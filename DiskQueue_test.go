@@ -0,0 +1,364 @@
+package mbsyslog_test
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/venutios/mbsyslog"
+)
+
+//unreachableAddress returns a local TCP address nothing is listening on
+func unreachableAddress(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	listener.Close()
+	return listener.Addr().String()
+}
+
+//queueMessages sends the messages through a new client using the queue
+func queueMessages(t *testing.T, queue *mbsyslog.DiskQueue, addr string, msgs []string) {
+	t.Helper()
+	client := mbsyslog.NewClient(false, mbsyslog.WithTransport(mbsyslog.TransportTCP), mbsyslog.WithDiskQueue(queue))
+	for _, msg := range msgs {
+		if err := client.SendData(addr, []byte(msg)); err != nil {
+			t.Fatalf("Client.SendData() error: %s", err)
+		}
+	}
+}
+
+func TestDiskQueue_Restart(t *testing.T) {
+	msgs := make([]string, 20)
+	for index := range msgs {
+		msgs[index] = "<34>1 - mymachine.example.com su - - - message " + strconv.Itoa(index)
+	}
+
+	tests := []struct {
+		name    string
+		options []mbsyslog.DiskQueueOption
+	}{
+		{"SyncAlways", nil},
+		{"SyncPeriodic", []mbsyslog.DiskQueueOption{mbsyslog.WithSyncPolicy(mbsyslog.SyncPeriodic), mbsyslog.WithSyncInterval(10 * time.Millisecond)}},
+		{"SyncNever", []mbsyslog.DiskQueueOption{mbsyslog.WithSyncPolicy(mbsyslog.SyncNever)}},
+		{"Segments", []mbsyslog.DiskQueueOption{mbsyslog.WithSegmentSize(200)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			addr := unreachableAddress(t)
+
+			//the destination is down, so the messages wait in the queue
+			queue, err := mbsyslog.OpenDiskQueue(dir, tt.options...)
+			if err != nil {
+				t.Fatalf("OpenDiskQueue() error: %s", err)
+			}
+			queueMessages(t, queue, addr, msgs)
+			if queue.Len() != len(msgs) {
+				t.Errorf("DiskQueue.Len() = %d, want %d", queue.Len(), len(msgs))
+			}
+			if err := queue.Close(); err != nil {
+				t.Fatalf("DiskQueue.Close() error: %s", err)
+			}
+
+			//after a restart they are delivered in order once the destination is up
+			listener, err := net.Listen("tcp", addr)
+			if err != nil {
+				t.Skipf("Failed to listen on %s again: %s", addr, err)
+			}
+			defer listener.Close()
+
+			queue, err = mbsyslog.OpenDiskQueue(dir, tt.options...)
+			if err != nil {
+				t.Fatalf("OpenDiskQueue() error: %s", err)
+			}
+			defer queue.Close()
+			if queue.Len() != len(msgs) {
+				t.Fatalf("DiskQueue.Len() after restart = %d, want %d", queue.Len(), len(msgs))
+			}
+			client := mbsyslog.NewClient(false, mbsyslog.WithTransport(mbsyslog.TransportTCP), mbsyslog.WithDiskQueue(queue))
+			defer client.Close()
+
+			conn, err := listener.Accept()
+			if err != nil {
+				t.Fatalf("Failed to accept: %s", err)
+			}
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			reader := bufio.NewReader(conn)
+			for _, want := range msgs {
+				got, err := readOctetCountedFrame(reader)
+				if err != nil {
+					t.Fatalf("Message never received: %s", err)
+				}
+				if got != want {
+					t.Errorf("Received %q, want %q", got, want)
+				}
+			}
+
+			client.Wait()
+			if queue.Len() != 0 || queue.Size() != 0 {
+				t.Errorf("DiskQueue.Len(), Size() = %d, %d, want 0", queue.Len(), queue.Size())
+			}
+			if segments, _ := filepath.Glob(filepath.Join(dir, "*.seg")); len(segments) != 1 {
+				t.Errorf("Delivered segments not deleted: %v", segments)
+			}
+		})
+	}
+}
+
+func TestDiskQueue_MaxSize(t *testing.T) {
+	queue, err := mbsyslog.OpenDiskQueue(t.TempDir(), mbsyslog.WithMaxDiskSize(200))
+	if err != nil {
+		t.Fatalf("OpenDiskQueue() error: %s", err)
+	}
+	defer queue.Close()
+
+	client := mbsyslog.NewClient(false, mbsyslog.WithTransport(mbsyslog.TransportTCP), mbsyslog.WithDiskQueue(queue))
+	addr := unreachableAddress(t)
+	for index := 0; index < 10; index++ {
+		err := client.SendData(addr, []byte("<34>1 - - - - - - fifty bytes of message data"))
+		if err == mbsyslog.ErrQueueFull {
+			if index == 0 || queue.Size() > 200 || queue.Len() != index {
+				t.Errorf("Client.SendData() queue full after %d messages, DiskQueue.Len(), Size() = %d, %d", index, queue.Len(), queue.Size())
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("Client.SendData() error: %s", err)
+		}
+	}
+	t.Error("Client.SendData() never returned ErrQueueFull")
+}
+
+func TestDiskQueue_Corrupt(t *testing.T) {
+	msgs := []string{"<34>1 - - - - - - first", "<34>1 - - - - - - second", "<34>1 - - - - - - third"}
+
+	tests := []struct {
+		name    string
+		corrupt func(segments []string) error
+		wantLen int
+	}{
+		{"HalfWritten", func(segments []string) error {
+			file, err := os.OpenFile(segments[len(segments)-1], os.O_WRONLY|os.O_APPEND, 0600)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			_, err = file.Write([]byte{0, 0, 0, 40, 1, 2})
+			return err
+		}, 3},
+		{"LastChecksum", func(segments []string) error {
+			return flipLastByte(segments[len(segments)-1])
+		}, 2},
+		{"EarlierChecksum", func(segments []string) error {
+			return flipLastByte(segments[0])
+		}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			queue, err := mbsyslog.OpenDiskQueue(dir, mbsyslog.WithSegmentSize(1))
+			if err != nil {
+				t.Fatalf("OpenDiskQueue() error: %s", err)
+			}
+			queueMessages(t, queue, unreachableAddress(t), msgs)
+			queue.Close()
+
+			segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+			if len(segments) != len(msgs) {
+				t.Fatalf("Segments = %v, want one per message", segments)
+			}
+			if err := tt.corrupt(segments); err != nil {
+				t.Fatalf("Failed to corrupt the queue: %s", err)
+			}
+
+			queue, err = mbsyslog.OpenDiskQueue(dir, mbsyslog.WithSegmentSize(1))
+			if err != nil {
+				t.Fatalf("OpenDiskQueue() error: %s", err)
+			}
+			defer queue.Close()
+			if queue.Len() != tt.wantLen {
+				t.Errorf("DiskQueue.Len() = %d, want %d", queue.Len(), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestDiskQueue_CorruptDelivery(t *testing.T) {
+	msgs := []string{"<34>1 - - - - - - first", "<34>1 - - - - - - second", "<34>1 - - - - - - third"}
+	dir := t.TempDir()
+	addr := unreachableAddress(t)
+
+	queue, err := mbsyslog.OpenDiskQueue(dir)
+	if err != nil {
+		t.Fatalf("OpenDiskQueue() error: %s", err)
+	}
+	queueMessages(t, queue, addr, msgs)
+	queue.Close()
+
+	//damage the second message in the middle of the segment
+	segments, _ := filepath.Glob(filepath.Join(dir, "*.seg"))
+	if len(segments) != 1 {
+		t.Fatalf("Segments = %v, want one", segments)
+	}
+	data, err := os.ReadFile(segments[0])
+	if err != nil {
+		t.Fatalf("Failed to read the segment: %s", err)
+	}
+	//each record is a length, checksum, address length, address and the message
+	first := 8 + 2 + len(addr) + len(msgs[0])
+	data[first+8+2+len(addr)] ^= 0xFF
+	if err := os.WriteFile(segments[0], data, 0600); err != nil {
+		t.Fatalf("Failed to corrupt the segment: %s", err)
+	}
+
+	_, frames := frameListener(t, addr)
+	queue, err = mbsyslog.OpenDiskQueue(dir)
+	if err != nil {
+		t.Fatalf("OpenDiskQueue() error: %s", err)
+	}
+	defer queue.Close()
+	if queue.Len() != 2 {
+		t.Errorf("DiskQueue.Len() = %d, want 2", queue.Len())
+	}
+	failures := make(chan error, 5)
+	client := mbsyslog.NewClient(false,
+		mbsyslog.WithTransport(mbsyslog.TransportTCP),
+		mbsyslog.WithDiskQueue(queue),
+		mbsyslog.WithErrorHandler(func(err *mbsyslog.SendError) {
+			failures <- err.Err
+		}))
+	defer client.Close()
+
+	//the damaged message is skipped and the ones after it still arrive
+	for _, want := range []string{msgs[0], msgs[2]} {
+		select {
+		case got := <-frames:
+			if got != want {
+				t.Errorf("Received %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Message %q never received", want)
+		}
+	}
+	client.Wait()
+	select {
+	case err := <-failures:
+		if !errors.Is(err, mbsyslog.ErrCorruptRecord) {
+			t.Errorf("Error handler called with %v, want %v", err, mbsyslog.ErrCorruptRecord)
+		}
+	default:
+		t.Error("Error handler not called for the damaged message")
+	}
+	if corrupt, _ := filepath.Glob(filepath.Join(dir, "*.corrupt")); len(corrupt) != 1 {
+		t.Errorf("Damaged data not kept: %v", corrupt)
+	}
+	if queue.Len() != 0 || queue.Size() != 0 {
+		t.Errorf("DiskQueue.Len(), Size() = %d, %d, want 0", queue.Len(), queue.Size())
+	}
+}
+
+func TestDiskQueue_PermanentError(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer conn.Close()
+
+	queue, err := mbsyslog.OpenDiskQueue(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenDiskQueue() error: %s", err)
+	}
+	defer queue.Close()
+	failures := make(chan error, 5)
+	client := mbsyslog.NewClient(false,
+		mbsyslog.WithDiskQueue(queue),
+		mbsyslog.WithErrorHandler(func(err *mbsyslog.SendError) {
+			failures <- err.Err
+		}))
+	defer client.Close()
+
+	//a datagram that is too large can never be sent, so it mustn't hold up the
+	//message after it
+	tooLarge := "<34>1 - - - - - - " + strings.Repeat("x", 70000)
+	for _, msg := range []string{tooLarge, "<34>1 - - - - - - after"} {
+		if err := client.SendData(conn.LocalAddr().String(), []byte(msg)); err != nil {
+			t.Fatalf("Client.SendData() error: %s", err)
+		}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buffer := make([]byte, 1024)
+	count, _, err := conn.ReadFrom(buffer)
+	if err != nil {
+		t.Fatalf("Message never received: %s", err)
+	}
+	if got := string(buffer[:count]); got != "<34>1 - - - - - - after" {
+		t.Errorf("Received %q", got)
+	}
+	client.Wait()
+	select {
+	case err := <-failures:
+		if err == nil {
+			t.Error("Error handler called without an error")
+		}
+	default:
+		t.Error("Error handler not called for the message that is too large")
+	}
+}
+
+func TestDiskQueue_Batching(t *testing.T) {
+	addr, frames := frameListener(t, "127.0.0.1:0")
+	queue, err := mbsyslog.OpenDiskQueue(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenDiskQueue() error: %s", err)
+	}
+	defer queue.Close()
+	client := mbsyslog.NewClient(false,
+		mbsyslog.WithTransport(mbsyslog.TransportTCP),
+		mbsyslog.WithBatching(1<<16, time.Hour),
+		mbsyslog.WithDiskQueue(queue))
+	defer client.Close()
+
+	msgs := []string{"<34>1 - - - - - - first", "<34>1 - - - - - - second"}
+	for _, msg := range msgs {
+		if err := client.SendData(addr, []byte(msg)); err != nil {
+			t.Fatalf("Client.SendData() error: %s", err)
+		}
+	}
+
+	//a message only leaves the queue once it has been written, not when it is
+	//added to a batch
+	client.Wait()
+	if queue.Len() != 0 {
+		t.Errorf("DiskQueue.Len() = %d, want 0", queue.Len())
+	}
+	for _, want := range msgs {
+		select {
+		case got := <-frames:
+			if got != want {
+				t.Errorf("Received %q, want %q", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Message %q never received", want)
+		}
+	}
+}
+
+func flipLastByte(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	data[len(data)-1] ^= 0xFF
+	return os.WriteFile(path, data, 0600)
+}
@@ -2,7 +2,10 @@ package mbsyslog
 
 import (
 	"errors"
+	"fmt"
+	"iter"
 	"strings"
+	"unicode/utf8"
 )
 
 //Element is a single element in the syslog structured data, which may contain
@@ -10,55 +13,115 @@ import (
 type Element struct {
 	id         string
 	parameters []*Parameter
+	//escaped is set when the parameter values are kept as they were sent,
+	//including any escaping backslashes
+	escaped bool
 }
 
 //NewElement initialize the elements, parsing the string, and creating all parameters
 //found. If the raw string can't be parsed nil is returned with an error.
+//Parameter values are kept as they were sent, including escaping backslashes.
 func NewElement(raw string) (*Element, error) {
+	return parseElement(raw, false)
+}
+
+//parseElement parses the element like NewElement, removing the backslash from
+//the escaped characters \", \\ and \] in parameter values when unescape is set
+func parseElement(raw string, unescape bool) (*Element, error) {
 	result := new(Element)
+	result.escaped = !unescape
 	index := strings.Index(raw, " ")
 
-	//parse the id from the string
+	//parse the id from the string, an element may have no parameters
 	if index == -1 {
-		return nil, errors.New("Id not found in element")
+		index = len(raw)
 	}
 	result.id = raw[0:index]
+	if result.id == "" {
+		return nil, errors.New("Id not found in element")
+	}
 	index++
 
 	//Continuing parsing the next parameter until the string is consumed
 	for index < len(raw) {
 		//parameters take the form name="value" and separated by spaces
-		equals := strings.Index(raw[index:], "=") + index
-		openQuote := -1
+		equals := strings.Index(raw[index:], "=")
 
-		if equals != -1 {
-			openQuote = strings.Index(raw[equals:], "\"") + equals
+		//if the value isn't quoted, the parameter and element is malformed
+		if equals == -1 || index+equals+1 >= len(raw) || raw[index+equals+1] != '"' {
+			return nil, errors.New("Element is malformed and not parseable")
 		}
+		equals += index
 
 		//quotes can be escaped, search for an unescaped closing
-		endQuote := findEndQuote(raw, openQuote+1)
-
-		//if any piece wasn't found, the parameter and element is malformed
-		if equals == -1 || openQuote == -1 || endQuote == -1 {
+		endQuote := findEndQuote(raw, equals+2)
+		if endQuote == -1 {
 			return nil, errors.New("Element is malformed and not parseable")
 		}
 
 		//Extract the parameter, and skip the space
-		result.parameters = append(result.parameters, NewParameter(raw[index:equals], raw[openQuote+1:endQuote]))
+		value := raw[equals+2 : endQuote]
+		if unescape {
+			value = unescapeParamValue(value)
+		}
+		result.parameters = append(result.parameters, NewParameter(raw[index:equals], value))
 		index = endQuote + 2
 	}
 
 	return result, nil
 }
 
+//findEndQuote returns the index of the first quote that isn't escaped with a
+//backslash, or -1 if there isn't one
 func findEndQuote(raw string, index int) int {
-	//continue searching while a candidate location is present, and the
-	//current and previous chars don't equal \"
-	for index != -1 && raw[index] != '"' && raw[index-1] != '\'' {
-		index++
+	for ; index < len(raw); index++ {
+		switch raw[index] {
+		case '\\':
+			index++ //skip the escaped character
+		case '"':
+			return index
+		}
+	}
+
+	return -1
+}
+
+//unescapeParamValue removes the backslash from the characters RFC 5424 requires
+//to be escaped. A backslash before any other character is kept.
+func unescapeParamValue(value string) string {
+	if strings.IndexByte(value, '\\') == -1 {
+		return value
 	}
 
-	return index
+	var result strings.Builder
+	for index := 0; index < len(value); index++ {
+		if value[index] == '\\' && index+1 < len(value) {
+			switch value[index+1] {
+			case '"', '\\', ']':
+				index++
+			}
+		}
+		result.WriteByte(value[index])
+	}
+	return result.String()
+}
+
+//escapeParamValue escapes the characters RFC 5424 doesn't allow unescaped in a
+//parameter value
+func escapeParamValue(value string) string {
+	if strings.IndexAny(value, "\"\\]") == -1 {
+		return value
+	}
+
+	var result strings.Builder
+	for index := 0; index < len(value); index++ {
+		switch value[index] {
+		case '"', '\\', ']':
+			result.WriteByte('\\')
+		}
+		result.WriteByte(value[index])
+	}
+	return result.String()
 }
 
 //ID returns the id of the element
@@ -71,7 +134,109 @@ func (e Element) Parameter(index int) Parameter {
 	return *e.parameters[index]
 }
 
+//Get returns the value of the first parameter with the name, and false if
+//there isn't one. The value is unescaped whichever mode the message was
+//parsed in, unlike Parameter.Value which keeps it as sent.
+func (e Element) Get(name string) (string, bool) {
+	for _, p := range e.parameters {
+		if p.name == name {
+			return e.value(p), true
+		}
+	}
+	return "", false
+}
+
+//GetAll returns the unescaped values of every parameter with the name, in the
+//order they appear. RFC 5424 allows a parameter name to be repeated in an
+//element.
+func (e Element) GetAll(name string) []string {
+	var result []string
+	for _, p := range e.parameters {
+		if p.name == name {
+			result = append(result, e.value(p))
+		}
+	}
+	return result
+}
+
+//All returns an iterator over the names and unescaped values of the
+//parameters in the order they appear
+func (e Element) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		for _, p := range e.parameters {
+			if !yield(p.name, e.value(p)) {
+				return
+			}
+		}
+	}
+}
+
+//value returns the parameter value with any escaping removed
+func (e Element) value(p *Parameter) string {
+	if e.escaped {
+		return unescapeParamValue(p.value)
+	}
+	return p.value
+}
+
 //Count returns the number of parameters in the element
 func (e Element) Count() int {
 	return len(e.parameters)
 }
+
+//String returns the element in the RFC 5424 format, with parameter values
+//escaped unless they were kept as sent:
+//
+//	[id name="value"]
+func (e Element) String() string {
+	var result strings.Builder
+	result.WriteByte('[')
+	result.WriteString(e.id)
+	for _, p := range e.parameters {
+		result.WriteByte(' ')
+		result.WriteString(p.name)
+		result.WriteString("=\"")
+		if e.escaped {
+			result.WriteString(p.value)
+		} else {
+			result.WriteString(escapeParamValue(p.value))
+		}
+		result.WriteByte('"')
+	}
+	result.WriteByte(']')
+	return result.String()
+}
+
+//validate checks the element id and parameter names are allowed by RFC 5424
+func (e Element) validate() error {
+	if !isSDName(e.id) {
+		return fmt.Errorf("Invalid structured data id %q", e.id)
+	}
+	for _, p := range e.parameters {
+		if p == nil {
+			return fmt.Errorf("Missing structured data parameter in %q", e.id)
+		}
+		if !isSDName(p.name) {
+			return fmt.Errorf("Invalid structured data parameter name %q", p.name)
+		}
+		if !utf8.ValidString(p.value) {
+			return fmt.Errorf("Invalid structured data parameter value for %q", p.name)
+		}
+	}
+	return nil
+}
+
+//isSDName checks the name is 1 to 32 printable US-ASCII characters, excluding
+//'=', ' ', ']' and '"', as required for SD-ID and PARAM-NAME
+func isSDName(name string) bool {
+	if len(name) == 0 || len(name) > 32 {
+		return false
+	}
+	for index := 0; index < len(name); index++ {
+		switch c := name[index]; {
+		case c < 33 || c > 126, c == '=', c == ']', c == '"':
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,152 @@
+package mbsyslog
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strconv"
+)
+
+//maxFrameLengthDigits limits how many digits an octet count may have before
+//the frame is treated as malformed
+const maxFrameLengthDigits = 10
+
+//framing is the method used to separate messages in a stream
+type framing int
+
+const (
+	//framingUnknown detects the framing from the first frame in the stream
+	framingUnknown framing = iota
+	//framingOctetCounting prefixes each message with the message length
+	framingOctetCounting
+	//framingNonTransparent ends each message with a trailer character
+	framingNonTransparent
+)
+
+//frameOctetCounting prefixes the message with its length, as described in
+//RFC 6587 section 3.4.1:
+//
+//	MSG-LEN SP SYSLOG-MSG
+func frameOctetCounting(data []byte) []byte {
+	result := make([]byte, 0, len(data)+8)
+	result = strconv.AppendInt(result, int64(len(data)), 10)
+	result = append(result, ' ')
+	return append(result, data...)
+}
+
+//frameReader splits a stream of framed syslog messages into the individual
+//messages. Messages larger than the maximum size are truncated.
+type frameReader struct {
+	reader         *bufio.Reader
+	framing        framing
+	maxMessageSize int
+	//truncated is set when the last frame read was larger than the maximum
+	truncated bool
+}
+
+func newFrameReader(r io.Reader, f framing, maxMessageSize int) *frameReader {
+	result := new(frameReader)
+	result.reader = bufio.NewReader(r)
+	result.framing = f
+	result.maxMessageSize = maxMessageSize
+	return result
+}
+
+//readFrame reads the next message from the stream. When the framing is
+//unknown, the first frame decides it for the rest of the stream. Octet
+//counted frames always start with a digit, while a non-transparent frame
+//starts with the '<' of the priority (RFC 6587 section 3.4).
+func (f *frameReader) readFrame() ([]byte, error) {
+	f.truncated = false
+	if f.framing == framingUnknown {
+		start, err := f.reader.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if start[0] >= '0' && start[0] <= '9' {
+			f.framing = framingOctetCounting
+		} else {
+			f.framing = framingNonTransparent
+		}
+	}
+
+	if f.framing == framingOctetCounting {
+		return f.readOctetCounted()
+	}
+	return f.readNonTransparent()
+}
+
+//readOctetCounted reads the next octet counted frame from the stream
+func (f *frameReader) readOctetCounted() ([]byte, error) {
+	length := 0
+	digits := 0
+	for {
+		b, err := f.reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == ' ' && digits > 0 {
+			break
+		}
+		if b < '0' || b > '9' || digits == maxFrameLengthDigits {
+			return nil, errors.New("Invalid octet count in frame")
+		}
+		length = length*10 + int(b-'0')
+		digits++
+	}
+
+	size := length
+	if size > f.maxMessageSize {
+		size = f.maxMessageSize
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(f.reader, data); err != nil {
+		return nil, err
+	}
+
+	//skip whatever didn't fit so the next frame starts in the right place
+	if length > size {
+		f.truncated = true
+		if _, err := io.CopyN(ioutil.Discard, f.reader, int64(length-size)); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+//readNonTransparent reads the next frame ended by a trailer. The trailer is
+//normally LF, but NUL is accepted as some senders still use it. Empty frames
+//are skipped.
+func (f *frameReader) readNonTransparent() ([]byte, error) {
+	var data []byte
+	for {
+		b, err := f.reader.ReadByte()
+		if err != nil {
+			//the last message in a stream may not have a trailer
+			if err == io.EOF && len(data) > 0 {
+				return data, nil
+			}
+			return nil, err
+		}
+
+		if b == '\n' || b == 0 {
+			//some senders end frames with CR LF
+			if len(data) > 0 && data[len(data)-1] == '\r' {
+				data = data[:len(data)-1]
+			}
+			if len(data) > 0 {
+				return data, nil
+			}
+			continue
+		}
+
+		//keep consuming an oversized frame to find the trailer
+		if len(data) < f.maxMessageSize {
+			data = append(data, b)
+		} else {
+			f.truncated = true
+		}
+	}
+}
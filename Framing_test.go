@@ -0,0 +1,55 @@
+package mbsyslog
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFrameReader_ReadFrame(t *testing.T) {
+	tests := []struct {
+		name      string
+		framing   framing
+		stream    string
+		want      []string
+		truncated int
+	}{
+		{"OctetCounting", framingUnknown, "11 <34>1 first12 <34>1 second", []string{"<34>1 first", "<34>1 second"}, 0},
+		{"NonTransparentLF", framingUnknown, "<34>1 first\n<34>1 second\n", []string{"<34>1 first", "<34>1 second"}, 0},
+		{"NonTransparentCRLF", framingUnknown, "<34>1 first\r\n<34>1 second\r\n", []string{"<34>1 first", "<34>1 second"}, 0},
+		{"NonTransparentNUL", framingUnknown, "<34>1 first\x00<34>1 second\x00", []string{"<34>1 first", "<34>1 second"}, 0},
+		{"NonTransparentNoTrailer", framingUnknown, "<34>1 first\n<34>1 second", []string{"<34>1 first", "<34>1 second"}, 0},
+		{"NonTransparentEmptyFrames", framingUnknown, "\n\n<34>1 first\n\n", []string{"<34>1 first"}, 0},
+		{"OctetCountingTruncated", framingUnknown, "20 <34>1 0123456789abcd12 <34>1 second", []string{"<34>1 01234567", "<34>1 second"}, 1},
+		{"NonTransparentTruncated", framingUnknown, "<34>1 0123456789abcd\n<34>1 second\n", []string{"<34>1 01234567", "<34>1 second"}, 1},
+		{"OctetCountingInvalidCount", framingOctetCounting, "<34>1 first\n", nil, 0},
+		{"OctetCountingShort", framingOctetCounting, "20 <34>1 first", nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := newFrameReader(strings.NewReader(tt.stream), tt.framing, 14)
+			var got []string
+			truncated := 0
+			for {
+				data, err := reader.readFrame()
+				if err != nil {
+					if err != io.EOF && tt.want != nil {
+						t.Errorf("frameReader.readFrame() error = %v", err)
+					}
+					break
+				}
+				got = append(got, string(data))
+				if reader.truncated {
+					truncated++
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("frameReader.readFrame() = %q, want %q", got, tt.want)
+			}
+			if truncated != tt.truncated {
+				t.Errorf("frameReader.readFrame() truncated %d frames, want %d", truncated, tt.truncated)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package mbsyslog
+
+import "context"
+
+//Handler processes the messages received by a server. HandleMessage is called
+//concurrently for messages from different datagrams and connections, and
+//should return once the message has been dealt with. The message is not used
+//by the server after HandleMessage returns.
+type Handler interface {
+	HandleMessage(ctx context.Context, m *Message) error
+}
+
+//HandlerFunc allows an ordinary function to be used as a Handler
+type HandlerFunc func(ctx context.Context, m *Message) error
+
+//HandleMessage calls f(ctx, m)
+func (f HandlerFunc) HandleMessage(ctx context.Context, m *Message) error {
+	return f(ctx, m)
+}
+
+//Middleware wraps a handler with additional behaviour, such as filtering,
+//enriching or routing messages before they reach the handler
+type Middleware func(next Handler) Handler
+
+//Chain wraps the handler in the middleware. The first middleware sees each
+//message first, and the handler sees it last:
+//
+//	Chain(h, a, b) is the same as a(b(h))
+func Chain(handler Handler, middleware ...Middleware) Handler {
+	for index := len(middleware) - 1; index >= 0; index-- {
+		handler = middleware[index](handler)
+	}
+	return handler
+}
+
+//Filter is middleware that only passes on the messages the function returns
+//true for. Other messages are dropped without an error.
+func Filter(keep func(m *Message) bool) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, m *Message) error {
+			if !keep(m) {
+				return nil
+			}
+			return next.HandleMessage(ctx, m)
+		})
+	}
+}
+
+//ChannelHandler returns a handler that writes every message to the channel.
+//Writing blocks until the channel has room, or returns the context error when
+//the context is done first.
+func ChannelHandler(messages chan<- Message) Handler {
+	return HandlerFunc(func(ctx context.Context, m *Message) error {
+		select {
+		case messages <- *m:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
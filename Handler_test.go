@@ -0,0 +1,89 @@
+package mbsyslog_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/venutios/mbsyslog"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	record := func(name string) mbsyslog.Middleware {
+		return func(next mbsyslog.Handler) mbsyslog.Handler {
+			return mbsyslog.HandlerFunc(func(ctx context.Context, m *mbsyslog.Message) error {
+				order = append(order, name)
+				return next.HandleMessage(ctx, m)
+			})
+		}
+	}
+	handler := mbsyslog.HandlerFunc(func(ctx context.Context, m *mbsyslog.Message) error {
+		order = append(order, "handler")
+		return errors.New("handled")
+	})
+
+	m := mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<13>text"))
+	err := mbsyslog.Chain(handler, record("first"), record("second")).HandleMessage(context.Background(), m)
+	if err == nil || err.Error() != "handled" {
+		t.Errorf("Handler.HandleMessage() error = %v, want handled", err)
+	}
+	if want := []string{"first", "second", "handler"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("Chain() order = %v, want %v", order, want)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want bool
+	}{
+		{"Error", "<11>text", true},
+		{"Critical", "<10>text", true},
+		{"Warning", "<12>text", false},
+		{"Debug", "<15>text", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handled := false
+			handler := mbsyslog.Chain(mbsyslog.HandlerFunc(func(ctx context.Context, m *mbsyslog.Message) error {
+				handled = true
+				return nil
+			}), mbsyslog.Filter(func(m *mbsyslog.Message) bool {
+				return m.Severity() <= mbsyslog.MessageSeverityError
+			}))
+
+			m := mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte(tt.data))
+			if err := handler.HandleMessage(context.Background(), m); err != nil {
+				t.Errorf("Handler.HandleMessage() error = %v", err)
+			}
+			if handled != tt.want {
+				t.Errorf("Filter() passed = %v, want %v", handled, tt.want)
+			}
+		})
+	}
+}
+
+func TestChannelHandler(t *testing.T) {
+	messages := make(chan mbsyslog.Message, 1)
+	handler := mbsyslog.ChannelHandler(messages)
+	m := mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<13>text"))
+
+	if err := handler.HandleMessage(context.Background(), m); err != nil {
+		t.Fatalf("Handler.HandleMessage() error = %v", err)
+	}
+	if got := <-messages; got.String() != m.String() {
+		t.Errorf("ChannelHandler() message = %v, want %v", got, m)
+	}
+
+	//a full channel waits for the context
+	messages <- *m
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := handler.HandleMessage(ctx, m); err != context.Canceled {
+		t.Errorf("Handler.HandleMessage() error = %v, want %v", err, context.Canceled)
+	}
+}
@@ -2,19 +2,31 @@ package mbsyslog
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
+//utf8BOM is the byte order mark that starts UTF-8 content in RFC 5424
+const utf8BOM = "\xEF\xBB\xBF"
+
+//rfc5424TimeFormat is the RFC 3339 layout limited to the microsecond precision
+//allowed by RFC 5424
+const rfc5424TimeFormat = "2006-01-02T15:04:05.999999Z07:00"
+
+//maxPriority is the largest priority, local7 facility with debug severity
+const maxPriority = 191
+
 //Message is an implementation of RFC 3164, RFC 5424, and custom syslog message
 //formats. Parsing is tolerant of missing fields. A sample of custom message
 //formats supported:
 //
 //	<priority>content
 type Message struct {
-	source         *net.UDPAddr
+	source         net.Addr
 	raw            string
 	format         MessageFormat
 	priority       int
@@ -30,15 +42,31 @@ type Message struct {
 
 //NewMessage parses a syslog message into the component pieces
 func NewMessage(source *net.UDPAddr, data []byte) *Message {
+	return newMessage(source, data)
+}
+
+func newMessage(source net.Addr, data []byte) *Message {
 	result := new(Message)
 	result.source = source
-	result.parse(string(data))
+	result.parse(string(data), ParseOptions{Source: source})
 	return result
 }
 
-//Source returns UDP address source of the message
+//Source returns UDP address source of the message. Messages received over a
+//stream report the IP address and port of the remote end of the stream. IPv4
+//senders reaching a dual stack socket are reported with their IPv4 address.
 func (m Message) Source() net.UDPAddr {
-	return *m.source
+	switch addr := m.source.(type) {
+	case *net.UDPAddr:
+		if addr != nil {
+			return *addr
+		}
+	case *net.TCPAddr:
+		if addr != nil {
+			return net.UDPAddr{IP: addr.IP, Port: addr.Port, Zone: addr.Zone}
+		}
+	}
+	return net.UDPAddr{}
 }
 
 //Format returns the syslog message format of the message
@@ -103,12 +131,169 @@ func (m Message) Content() string {
 	return m.content
 }
 
-//String returns a string representation of the message
+//String returns a string representation of the message, starting with the
+//source IP address. IPv6 link-local sources include the zone. Messages that
+//weren't received from the network have no source address.
 func (m Message) String() string {
-	return m.source.IP.String() + " " + m.raw
+	source := m.Source()
+	if source.IP == nil {
+		return m.raw
+	}
+	if source.Zone != "" {
+		return source.IP.String() + "%" + source.Zone + " " + m.raw
+	}
+	return source.IP.String() + " " + m.raw
+}
+
+//MarshalRFC5424 renders the message in the RFC 5424 format. Missing fields are
+//written as the NILVALUE dash, and content is prefixed with the UTF-8 BOM
+//when it is valid UTF-8. An error is returned if a field holds characters or
+//a length RFC 5424 doesn't allow.
+func (m Message) MarshalRFC5424() ([]byte, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+
+	result := make([]byte, 0, 64+len(m.content))
+	result = append(result, '<')
+	result = strconv.AppendInt(result, int64(m.priority), 10)
+	result = append(result, ">1 "...)
+	if m.date.IsZero() {
+		result = append(result, '-')
+	} else {
+		result = m.date.AppendFormat(result, rfc5424TimeFormat)
+	}
+	result = append(result, ' ')
+	result = append(result, nilValue(m.hostname)...)
+	result = append(result, ' ')
+	result = append(result, nilValue(m.application)...)
+	result = append(result, ' ')
+	if m.processID < 0 {
+		result = append(result, '-')
+	} else {
+		result = strconv.AppendInt(result, int64(m.processID), 10)
+	}
+	result = append(result, ' ')
+	result = append(result, nilValue(m.messageID)...)
+	result = append(result, ' ')
+	result = append(result, m.structuredData.String()...)
+
+	if m.content != "" {
+		result = append(result, ' ')
+		if utf8.ValidString(m.content) {
+			result = append(result, utf8BOM...)
+		}
+		result = append(result, m.content...)
+	}
+	return result, nil
+}
+
+//MarshalRFC3164 renders the message in the RFC 3164 format. A missing date is
+//replaced with the current time, and a missing hostname with the source IP
+//address. Structured data has no place in RFC 3164, so it is not included.
+func (m Message) MarshalRFC3164() ([]byte, error) {
+	hostname := m.hostname
+	if hostname == "" {
+		if source := m.Source(); source.IP != nil {
+			hostname = source.IP.String()
+		} else {
+			hostname = "-"
+		}
+	}
+	return m.marshalBSD(hostname)
+}
+
+//marshalLocal renders the message in the format glibc syslog(3) writes to
+//local sockets, which is RFC 3164 without the hostname
+func (m Message) marshalLocal() ([]byte, error) {
+	return m.marshalBSD("")
+}
+
+//marshalBSD renders the RFC 3164 format, leaving out the hostname when it is
+//empty
+func (m Message) marshalBSD(hostname string) ([]byte, error) {
+	if m.priority < 0 || m.priority > maxPriority {
+		return nil, fmt.Errorf("Invalid priority %d", m.priority)
+	}
+	if hostname != "" && !isPrintASCII(hostname, 255) {
+		return nil, fmt.Errorf("Invalid hostname %q", hostname)
+	}
+	if m.application != "" && !isPrintASCII(m.application, 48) {
+		return nil, fmt.Errorf("Invalid application %q", m.application)
+	}
+
+	date := m.date
+	if date.IsZero() {
+		date = time.Now()
+	}
+
+	result := make([]byte, 0, 64+len(m.content))
+	result = append(result, '<')
+	result = strconv.AppendInt(result, int64(m.priority), 10)
+	result = append(result, '>')
+	result = date.AppendFormat(result, time.Stamp)
+	result = append(result, ' ')
+	if hostname != "" {
+		result = append(result, hostname...)
+		result = append(result, ' ')
+	}
+	if m.application != "" {
+		result = append(result, m.application...)
+		if m.processID >= 0 {
+			result = append(result, '[')
+			result = strconv.AppendInt(result, int64(m.processID), 10)
+			result = append(result, ']')
+		}
+		//a tag parsed tolerantly is kept whole, colon included
+		if !strings.HasSuffix(m.application, ":") {
+			result = append(result, ':')
+		}
+		result = append(result, ' ')
+	}
+	result = append(result, m.content...)
+	return result, nil
 }
 
-func (m *Message) parse(data string) {
+//validate checks the fields hold values that can be written in RFC 5424
+func (m Message) validate() error {
+	if m.priority < 0 || m.priority > maxPriority {
+		return fmt.Errorf("Invalid priority %d", m.priority)
+	}
+	if m.hostname != "" && !isPrintASCII(m.hostname, 255) {
+		return fmt.Errorf("Invalid hostname %q", m.hostname)
+	}
+	if m.application != "" && !isPrintASCII(m.application, 48) {
+		return fmt.Errorf("Invalid application %q", m.application)
+	}
+	if m.messageID != "" && !isPrintASCII(m.messageID, 32) {
+		return fmt.Errorf("Invalid message ID %q", m.messageID)
+	}
+	return m.structuredData.validate()
+}
+
+//nilValue returns the RFC 5424 NILVALUE dash for empty fields
+func nilValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+//isPrintASCII checks the value is 1 to maxLength printable US-ASCII characters,
+//the character set of RFC 5424 header fields
+func isPrintASCII(value string, maxLength int) bool {
+	if len(value) == 0 || len(value) > maxLength {
+		return false
+	}
+	for index := 0; index < len(value); index++ {
+		if value[index] < 33 || value[index] > 126 {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Message) parse(data string, options ParseOptions) {
 	var err error
 	index := 0
 
@@ -126,17 +311,26 @@ func (m *Message) parse(data string) {
 		index, err = m.parseVersion(index)
 		//version is missing, so parse as RFC 3164
 		if err != nil {
-			index, err = m.parseDate(index)
+			index, err = m.parseDate(index, options)
 			//only parse the 3164 headers if the date was present, otherwise
 			//assume it is a simple message
 			if err == nil {
-				index = m.parseHostname(index)
+				//messages from local sockets have no hostname
+				if !options.Local {
+					index = m.parseHostname(index)
+				}
 				index = m.parseApplication(index)
+				//the tag of a local message is split into the application and
+				//process ID, as in the strict RFC 3164 mode. Other messages keep
+				//the tag as sent.
+				if options.Local {
+					m.parseTag()
+				}
 				m.format = MessageFormatRFC3164
 			}
 			m.parseContent(index)
 		} else { //version present, so parse as RFC 5424
-			index, err = m.parseDate(index)
+			index, err = m.parseDate(index, options)
 			if err == nil {
 				index = m.parseHostname(index)
 				index = m.parseApplication(index)
@@ -174,11 +368,8 @@ func (m *Message) parsePriority() (int, error) {
 }
 
 func (m *Message) parseVersion(index int) (int, error) {
-	var err error
-
-	_, err = strconv.Atoi(string(m.raw[index]))
 	//if the current index is invalid, or not a digit, end version parsing
-	if len(m.raw) <= index || err != nil {
+	if len(m.raw) <= index || m.raw[index] < '0' || m.raw[index] > '9' {
 		return index, errors.New("Invalid data to parse version")
 	}
 
@@ -196,9 +387,11 @@ func (m *Message) parseVersion(index int) (int, error) {
 	return index, errors.New("Failed to parse version")
 }
 
-func (m *Message) parseDate(index int) (int, error) {
-	var err error
+//bsdTimestampLayouts are the RFC 3164 timestamp layouts. The day should be
+//padded with a space, but some senders leave the padding out.
+var bsdTimestampLayouts = []string{time.Stamp, "Jan 2 15:04:05"}
 
+func (m *Message) parseDate(index int, options ParseOptions) (int, error) {
 	//if the current index is invalid, end parsing of the date
 	if len(m.raw) <= index {
 		return index, errors.New("Invalid data to parse date")
@@ -209,34 +402,29 @@ func (m *Message) parseDate(index int) (int, error) {
 		return index + 2, nil
 	}
 
-	//Attempt the first supported date format
-	formatStr1 := "Jan 2 15:04:05"
-	spaceAfterDate := strings.Index(m.raw[index+len(formatStr1):], " ")
-	if spaceAfterDate != -1 {
-		m.date, err = time.Parse(formatStr1, m.raw[index:index+len(formatStr1)+spaceAfterDate])
-		if err == nil {
-			m.date = m.date.AddDate(time.Now().Year(), 0, 0)
-			return index + len(formatStr1) + spaceAfterDate + 1, nil
-		}
+	//RFC 5424 timestamps are RFC 3339 with any precision up to microseconds
+	//and either Z or a numeric offset, ending at the next space
+	end := strings.Index(m.raw[index:], " ")
+	if end == -1 {
+		end = len(m.raw) - index
 	}
-
-	//Attempt the second supported date format
-	formatStr2 := "2006-01-02T15:04:05.000Z"
-	spaceAfterDate = strings.Index(m.raw[index+len(formatStr2):], " ")
-	if spaceAfterDate != -1 {
-		m.date, err = time.Parse(formatStr2, m.raw[index:index+len(formatStr2)+spaceAfterDate])
-		if err == nil {
-			return index + len(formatStr2) + spaceAfterDate + 1, nil
-		}
+	date, err := time.Parse(time.RFC3339Nano, m.raw[index:index+end])
+	if err == nil {
+		m.date = date
+		return index + end + 1, nil
 	}
 
-	//Attempt the third support date format
-	formatStr3 := "2006-01-02T15:04:05.000000-07:00"
-	spaceAfterDate = strings.Index(m.raw[index+len(formatStr3):], " ")
-	if spaceAfterDate != -1 {
-		m.date, err = time.Parse(formatStr3, m.raw[index:index+len(formatStr3)+spaceAfterDate])
+	//RFC 3164 timestamps have no year or time zone, which are filled in from
+	//the parse options
+	for _, layout := range bsdTimestampLayouts {
+		end = index + len(layout)
+		if len(m.raw) < end || (len(m.raw) > end && m.raw[end] != ' ') {
+			continue
+		}
+		date, err = time.Parse(layout, m.raw[index:end])
 		if err == nil {
-			return index + len(formatStr3) + spaceAfterDate + 1, nil
+			m.date = options.bsdTimestamp(date)
+			return end + 1, nil
 		}
 	}
 
@@ -281,6 +469,21 @@ func (m *Message) parseApplication(index int) int {
 	return index
 }
 
+//parseTag splits an RFC 3164 tag, such as su[123]: into the application and
+//process ID
+func (m *Message) parseTag() {
+	m.application = strings.TrimSuffix(m.application, ":")
+
+	open := strings.Index(m.application, "[")
+	if open > 0 && strings.HasSuffix(m.application, "]") {
+		processID, err := strconv.Atoi(m.application[open+1 : len(m.application)-1])
+		if err == nil {
+			m.processID = processID
+			m.application = m.application[:open]
+		}
+	}
+}
+
 func (m *Message) parseProcessID(index int) int {
 	//if the current index is invalid, end parsing
 	if len(m.raw) <= index {
@@ -347,13 +550,13 @@ func (m *Message) parseStructuredData(index int) int {
 
 	//Continue parsing the structured data until there are no more elements
 	for elementIndex < len(m.raw) && m.raw[elementIndex] == '[' {
-		endIndex := strings.Index(m.raw[elementIndex:], "]")
+		endIndex := findElementEnd(m.raw, elementIndex+1)
 		//if the end wasn't found, or the data couldnt be parsed
-		if endIndex == -1 || m.structuredData.addElement(m.raw[elementIndex+1:elementIndex+endIndex]) == false {
+		if endIndex == -1 || m.structuredData.addElement(m.raw[elementIndex+1:endIndex]) == false {
 			m.format = MessageFormatUnknown
 			return index
 		}
-		elementIndex = elementIndex + endIndex + 1
+		elementIndex = endIndex + 1
 	}
 
 	return elementIndex + 1
@@ -363,8 +566,11 @@ func (m *Message) parseContent(index int) {
 	if index < len(m.raw) {
 		m.content = m.raw[index:]
 
-		//per RFC5424, the data may be prefaced with BOM
-		if strings.HasPrefix(m.content, "BOM") {
+		//per RFC5424, the data may be prefaced with BOM. Some senders write
+		//the letters rather than the UTF-8 byte order mark.
+		if strings.HasPrefix(m.content, utf8BOM) {
+			m.content = m.content[len(utf8BOM):]
+		} else if strings.HasPrefix(m.content, "BOM") {
 			m.content = m.content[3:]
 		}
 	}
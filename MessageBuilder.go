@@ -0,0 +1,130 @@
+package mbsyslog
+
+import (
+	"errors"
+	"time"
+)
+
+//MessageBuilder constructs RFC 5424 messages to send with a client. Fields
+//that aren't set are left out of the message, except the priority which
+//defaults to the user facility and notice severity. Setters return the builder
+//so calls can be chained:
+//
+//	m, err := NewMessageBuilder().Severity(MessageSeverityError).Content("failed").Build()
+type MessageBuilder struct {
+	facility    MessageFacility
+	severity    MessageSeverity
+	date        time.Time
+	hostname    string
+	application string
+	processID   int
+	messageID   string
+	elements    []*Element
+	content     string
+}
+
+//NewMessageBuilder creates a builder for a new message
+func NewMessageBuilder() *MessageBuilder {
+	result := new(MessageBuilder)
+	result.facility = MessageFacilityUser
+	result.severity = MessageSeverityNotice
+	result.processID = -1
+	return result
+}
+
+//Facility sets the source of the message
+func (b *MessageBuilder) Facility(facility MessageFacility) *MessageBuilder {
+	b.facility = facility
+	return b
+}
+
+//Severity sets the severity level of the message
+func (b *MessageBuilder) Severity(severity MessageSeverity) *MessageBuilder {
+	b.severity = severity
+	return b
+}
+
+//Timestamp sets the date and time of the message
+func (b *MessageBuilder) Timestamp(date time.Time) *MessageBuilder {
+	b.date = date
+	return b
+}
+
+//Hostname sets the machine that originally sent the message, up to 255
+//printable US-ASCII characters
+func (b *MessageBuilder) Hostname(hostname string) *MessageBuilder {
+	b.hostname = hostname
+	return b
+}
+
+//Application sets the application that sent the message, up to 48 printable
+//US-ASCII characters
+func (b *MessageBuilder) Application(application string) *MessageBuilder {
+	b.application = application
+	return b
+}
+
+//ProcessID sets the process that sent the message, or -1 to leave it out
+func (b *MessageBuilder) ProcessID(processID int) *MessageBuilder {
+	b.processID = processID
+	return b
+}
+
+//MessageID sets the type of the message, up to 32 printable US-ASCII
+//characters
+func (b *MessageBuilder) MessageID(messageID string) *MessageBuilder {
+	b.messageID = messageID
+	return b
+}
+
+//Element adds a structured data element with the parameters. The id and
+//parameter names are up to 32 printable US-ASCII characters, excluding '=',
+//' ', ']' and '"'. Each id can only be added once.
+func (b *MessageBuilder) Element(id string, parameters ...*Parameter) *MessageBuilder {
+	e := new(Element)
+	e.id = id
+	e.parameters = parameters
+	b.elements = append(b.elements, e)
+	return b
+}
+
+//Content sets the free form text of the message
+func (b *MessageBuilder) Content(content string) *MessageBuilder {
+	b.content = content
+	return b
+}
+
+//Build creates the message, or returns an error if a field has a length or
+//characters RFC 5424 doesn't allow
+func (b *MessageBuilder) Build() (*Message, error) {
+	if b.facility < MessageFacilityKernel || b.facility > MessageFacilityLocal7 {
+		return nil, errors.New("Invalid facility " + b.facility.String())
+	}
+	if b.severity < MessageSeverityEmergency || b.severity > MessageSeverityDebug {
+		return nil, errors.New("Invalid severity " + b.severity.String())
+	}
+	if b.processID < -1 {
+		return nil, errors.New("Invalid process ID")
+	}
+
+	result := new(Message)
+	result.format = MessageFormatRFC5424
+	result.priority = int(b.facility)*8 + int(b.severity)
+	result.version = 1
+	result.date = b.date
+	result.hostname = b.hostname
+	result.application = b.application
+	result.processID = b.processID
+	result.messageID = b.messageID
+	result.content = b.content
+	if len(b.elements) > 0 {
+		result.structuredData.elements = append([]*Element(nil), b.elements...)
+	}
+
+	raw, err := result.MarshalRFC5424()
+	if err != nil {
+		return nil, err
+	}
+	result.raw = string(raw)
+	return result, nil
+}
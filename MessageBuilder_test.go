@@ -0,0 +1,57 @@
+package mbsyslog_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/venutios/mbsyslog"
+)
+
+func TestMessageBuilder_Build(t *testing.T) {
+	date := time.Date(2003, time.October, 11, 22, 14, 15, 3000000, time.UTC)
+	tests := []struct {
+		name    string
+		b       *mbsyslog.MessageBuilder
+		want    string
+		wantErr bool
+	}{
+		{"Defaults", mbsyslog.NewMessageBuilder(), "<13>1 - - - - - -", false},
+		{"AllFields", mbsyslog.NewMessageBuilder().Facility(mbsyslog.MessageFacilityAuth).Severity(mbsyslog.MessageSeverityCritical).Timestamp(date).Hostname("mymachine.example.com").Application("su").ProcessID(1234).MessageID("ID47").Content("'su root' failed for lonvick on /dev/pts/8"), "<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su 1234 ID47 - \xEF\xBB\xBF'su root' failed for lonvick on /dev/pts/8", false},
+		{"StructuredData", mbsyslog.NewMessageBuilder().Facility(mbsyslog.MessageFacilityLocal4).Timestamp(date).Element("exampleSDID@32473", mbsyslog.NewParameter("iut", "3"), mbsyslog.NewParameter("eventSource", "App]\"\\")).Element("origin", mbsyslog.NewParameter("ip", "192.0.2.1")), "<165>1 2003-10-11T22:14:15.003Z - - - - [exampleSDID@32473 iut=\"3\" eventSource=\"App\\]\\\"\\\\\"][origin ip=\"192.0.2.1\"]", false},
+		{"ElementNoParameters", mbsyslog.NewMessageBuilder().Element("meta"), "<13>1 - - - - - [meta]", false},
+		{"InvalidFacility", mbsyslog.NewMessageBuilder().Facility(24), "", true},
+		{"InvalidSeverity", mbsyslog.NewMessageBuilder().Severity(8), "", true},
+		{"InvalidProcessID", mbsyslog.NewMessageBuilder().ProcessID(-2), "", true},
+		{"HostnameSpace", mbsyslog.NewMessageBuilder().Hostname("my machine"), "", true},
+		{"HostnameTooLong", mbsyslog.NewMessageBuilder().Hostname(strings.Repeat("a", 256)), "", true},
+		{"ApplicationTooLong", mbsyslog.NewMessageBuilder().Application(strings.Repeat("a", 49)), "", true},
+		{"ApplicationNotASCII", mbsyslog.NewMessageBuilder().Application("appé"), "", true},
+		{"MessageIDTooLong", mbsyslog.NewMessageBuilder().MessageID(strings.Repeat("a", 33)), "", true},
+		{"ElementIDInvalid", mbsyslog.NewMessageBuilder().Element("bad=id"), "", true},
+		{"ElementIDTooLong", mbsyslog.NewMessageBuilder().Element(strings.Repeat("a", 33)), "", true},
+		{"ElementIDDuplicate", mbsyslog.NewMessageBuilder().Element("meta").Element("meta"), "", true},
+		{"ParameterNameInvalid", mbsyslog.NewMessageBuilder().Element("meta", mbsyslog.NewParameter("bad name", "value")), "", true},
+		{"ParameterValueNotUTF8", mbsyslog.NewMessageBuilder().Element("meta", mbsyslog.NewParameter("name", "\xFF")), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := tt.b.Build()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MessageBuilder.Build() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := m.String(); got != tt.want {
+				t.Errorf("MessageBuilder.Build() = %q, want %q", got, tt.want)
+			}
+			if got, _ := m.MarshalRFC5424(); string(got) != tt.want {
+				t.Errorf("Message.MarshalRFC5424() = %q, want %q", got, tt.want)
+			}
+			if m.Format() != mbsyslog.MessageFormatRFC5424 {
+				t.Errorf("Message.Format() = %s, want %s", m.Format(), mbsyslog.MessageFormatRFC5424)
+			}
+		})
+	}
+}
@@ -16,6 +16,7 @@ func TestMessage_Source(t *testing.T) {
 		want net.UDPAddr
 	}{
 		{"SimpleValid", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<151>The quick brown fox jumps over the lazy dog")), net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}},
+		{"SimpleIPv6", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 12345, Zone: ""}, []byte("<151>The quick brown fox jumps over the lazy dog")), net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 12345}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -147,7 +148,7 @@ func TestMessage_Date(t *testing.T) {
 		want time.Time
 	}{
 		{"SimpleValid", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<151>The quick brown fox jumps over the lazy dog")), time.Time{}},
-		{"RFC3164Valid", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<3>Nov 10 14:38:52 machineName appName The quick brown fox jumps over the lazy dog")), time.Date(time.Now().Year(), time.November, 10, 14, 38, 52, 0, time.UTC)},
+		{"RFC3164Valid", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<3>Nov 10 14:38:52 machineName appName The quick brown fox jumps over the lazy dog")), bsdDate(time.November, 10, 14, 38, 52)},
 		{"RFC5424Valid1", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - BOM'su root' failed for lonvick on /dev/pts/8")), time.Date(2003, time.October, 11, 22, 14, 15, 3000000, time.UTC)},
 		{"RFC5424Valid2", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<165>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 myproc 8710 - - %% It's time to make the do-nuts.")), func() time.Time { date, _ := time.Parse(time.RFC3339, "2003-08-24T05:14:15.000003-07:00"); return date }()},
 		{"RFC5424Valid3", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut=\"3\" eventSource=\"Application\" eventID=\"1011\"] BOMAn application event log entry...")), time.Date(2003, time.October, 11, 22, 14, 15, 3000000, time.UTC)},
@@ -162,6 +163,80 @@ func TestMessage_Date(t *testing.T) {
 	}
 }
 
+func TestMessage_DateFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		date   string
+		format mbsyslog.MessageFormat
+		want   time.Time
+	}{
+		{"RFC5424Seconds", "1 2003-10-11T22:14:15Z", mbsyslog.MessageFormatRFC5424, time.Date(2003, time.October, 11, 22, 14, 15, 0, time.UTC)},
+		{"RFC5424Fraction1", "1 2003-10-11T22:14:15.1Z", mbsyslog.MessageFormatRFC5424, time.Date(2003, time.October, 11, 22, 14, 15, 100000000, time.UTC)},
+		{"RFC5424Fraction2", "1 2003-10-11T22:14:15.12Z", mbsyslog.MessageFormatRFC5424, time.Date(2003, time.October, 11, 22, 14, 15, 120000000, time.UTC)},
+		{"RFC5424Fraction3", "1 2003-10-11T22:14:15.123Z", mbsyslog.MessageFormatRFC5424, time.Date(2003, time.October, 11, 22, 14, 15, 123000000, time.UTC)},
+		{"RFC5424Fraction4", "1 2003-10-11T22:14:15.1234Z", mbsyslog.MessageFormatRFC5424, time.Date(2003, time.October, 11, 22, 14, 15, 123400000, time.UTC)},
+		{"RFC5424Fraction5", "1 2003-10-11T22:14:15.12345Z", mbsyslog.MessageFormatRFC5424, time.Date(2003, time.October, 11, 22, 14, 15, 123450000, time.UTC)},
+		{"RFC5424Fraction6", "1 2003-10-11T22:14:15.123456Z", mbsyslog.MessageFormatRFC5424, time.Date(2003, time.October, 11, 22, 14, 15, 123456000, time.UTC)},
+		{"RFC5424TrailingZeros", "1 2003-10-11T22:14:15.000000Z", mbsyslog.MessageFormatRFC5424, time.Date(2003, time.October, 11, 22, 14, 15, 0, time.UTC)},
+		{"RFC5424OffsetZero", "1 2003-10-11T22:14:15+00:00", mbsyslog.MessageFormatRFC5424, time.Date(2003, time.October, 11, 22, 14, 15, 0, time.UTC)},
+		{"RFC5424OffsetNegative", "1 2003-10-11T22:14:15-07:00", mbsyslog.MessageFormatRFC5424, time.Date(2003, time.October, 12, 5, 14, 15, 0, time.UTC)},
+		{"RFC5424OffsetHalfHour", "1 2003-10-11T22:14:15.003+05:30", mbsyslog.MessageFormatRFC5424, time.Date(2003, time.October, 11, 16, 44, 15, 3000000, time.UTC)},
+		{"RFC5424OffsetMicroseconds", "1 2003-08-24T05:14:15.000003-07:00", mbsyslog.MessageFormatRFC5424, time.Date(2003, time.August, 24, 12, 14, 15, 3000, time.UTC)},
+		{"RFC5424Nil", "1 -", mbsyslog.MessageFormatRFC5424, time.Time{}},
+		{"RFC3164DayPadded", "Nov  5 14:38:52", mbsyslog.MessageFormatRFC3164, bsdDate(time.November, 5, 14, 38, 52)},
+		{"RFC3164DayUnpadded", "Nov 5 14:38:52", mbsyslog.MessageFormatRFC3164, bsdDate(time.November, 5, 14, 38, 52)},
+		{"RFC3164TwoDigitDay", "Nov 10 14:38:52", mbsyslog.MessageFormatRFC3164, bsdDate(time.November, 10, 14, 38, 52)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<13>"+tt.date+" machineName appName: text"))
+			if m.Format() != tt.format {
+				t.Fatalf("Message.Format() = %v, want %v", m.Format(), tt.format)
+			}
+			if got := m.Date(); !got.Equal(tt.want) {
+				t.Errorf("Message.Date() = %v, want %v", got, tt.want)
+			}
+			if m.Hostname() != "machineName" {
+				t.Errorf("Message.Hostname() = %v, want machineName", m.Hostname())
+			}
+		})
+	}
+}
+
+//bsdDate is the date given to an RFC 3164 timestamp received now, which is in
+//the previous year when it would be more than a day in the future
+func bsdDate(month time.Month, day, hour, minute, second int) time.Time {
+	latest := time.Now().Add(24 * time.Hour)
+	result := time.Date(latest.Year(), month, day, hour, minute, second, 0, time.UTC)
+	if result.After(latest) {
+		result = result.AddDate(-1, 0, 0)
+	}
+	return result
+}
+
+func TestMessage_DateShort(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		format mbsyslog.MessageFormat
+	}{
+		{"Priority", "<13>", mbsyslog.MessageFormatSimple},
+		{"Version", "<13>1", mbsyslog.MessageFormatSimple},
+		{"PartialRFC3164", "<13>Nov", mbsyslog.MessageFormatSimple},
+		{"PartialRFC5424", "<13>1 2003-10", mbsyslog.MessageFormatSimple},
+		{"RFC3164DateOnly", "<13>Nov 10 14:38:52", mbsyslog.MessageFormatRFC3164},
+		{"RFC5424DateOnly", "<13>1 2003-10-11T22:14:15Z", mbsyslog.MessageFormatRFC5424},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte(tt.data))
+			if m.Format() != tt.format {
+				t.Errorf("Message.Format() = %v, want %v", m.Format(), tt.format)
+			}
+		})
+	}
+}
+
 func TestMessage_Hostname(t *testing.T) {
 	tests := []struct {
 		name string
@@ -192,6 +267,7 @@ func TestMessage_Application(t *testing.T) {
 	}{
 		{"SimpleValid", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<151>The quick brown fox jumps over the lazy dog")), ""},
 		{"RFC3164Valid", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<3>Nov 10 14:38:52 machineName appName The quick brown fox jumps over the lazy dog")), "appName"},
+		{"RFC3164Tag", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<13>Nov 10 14:38:52 machineName su[1234]: The quick brown fox jumps over the lazy dog")), "su[1234]:"},
 		{"RFC5424Valid1", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - BOM'su root' failed for lonvick on /dev/pts/8")), "su"},
 		{"RFC5424Valid2", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<165>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 myproc 8710 - - %% It's time to make the do-nuts.")), "myproc"},
 		{"RFC5424Valid3", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut=\"3\" eventSource=\"Application\" eventID=\"1011\"] BOMAn application event log entry...")), "evntslog"},
@@ -214,6 +290,7 @@ func TestMessage_ProcessID(t *testing.T) {
 	}{
 		{"SimpleValid", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<151>The quick brown fox jumps over the lazy dog")), -1},
 		{"RFC3164Valid", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<3>Nov 10 14:38:52 machineName appName The quick brown fox jumps over the lazy dog")), -1},
+		{"RFC3164Tag", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<13>Nov 10 14:38:52 machineName su[1234]: The quick brown fox jumps over the lazy dog")), -1},
 		{"RFC5424Valid1", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - BOM'su root' failed for lonvick on /dev/pts/8")), -1},
 		{"RFC5424Valid2", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<165>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 myproc 8710 - - %% It's time to make the do-nuts.")), 8710},
 		{"RFC5424Valid3", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut=\"3\" eventSource=\"Application\" eventID=\"1011\"] BOMAn application event log entry...")), -1},
@@ -258,6 +335,7 @@ func TestMessage_Content(t *testing.T) {
 	}{
 		{"SimpleValid", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<151>The quick brown fox jumps over the lazy dog")), "The quick brown fox jumps over the lazy dog"},
 		{"RFC3164Valid", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<3>Nov 10 14:38:52 machineName appName The quick brown fox jumps over the lazy dog")), "The quick brown fox jumps over the lazy dog"},
+		{"RFC3164Tag", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<13>Nov 10 14:38:52 machineName su[1234]: The quick brown fox jumps over the lazy dog")), "The quick brown fox jumps over the lazy dog"},
 		{"RFC5424Valid1", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - BOM'su root' failed for lonvick on /dev/pts/8")), "'su root' failed for lonvick on /dev/pts/8"},
 		{"RFC5424Valid2", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<165>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 myproc 8710 - - %% It's time to make the do-nuts.")), "%% It's time to make the do-nuts."},
 		{"RFC5424Valid3", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut=\"3\" eventSource=\"Application\" eventID=\"1011\"] BOMAn application event log entry...")), "An application event log entry..."},
@@ -279,6 +357,8 @@ func TestMessage_String(t *testing.T) {
 		want string
 	}{
 		{"SimpleValid", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<151>The quick brown fox jumps over the lazy dog")), "127.0.0.1 " + "<151>The quick brown fox jumps over the lazy dog"},
+		{"SimpleIPv6", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 12345}, []byte("<151>The quick brown fox jumps over the lazy dog")), "2001:db8::1 " + "<151>The quick brown fox jumps over the lazy dog"},
+		{"SimpleIPv6Zone", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 12345, Zone: "eth0"}, []byte("<151>The quick brown fox jumps over the lazy dog")), "fe80::1%eth0 " + "<151>The quick brown fox jumps over the lazy dog"},
 		{"RFC3164Valid", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<3>Nov 10 14:38:52 machineName appName The quick brown fox jumps over the lazy dog")), "127.0.0.1 " + "<3>Nov 10 14:38:52 machineName appName The quick brown fox jumps over the lazy dog"},
 		{"RFC5424Valid1", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut=\"3\" eventSource=\"Application\" eventID=\"1011\"] BOMAn application event log entry...")), "127.0.0.1 " + "<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut=\"3\" eventSource=\"Application\" eventID=\"1011\"] BOMAn application event log entry..."},
 		{"RFC5424Valid2", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut=\"3\" eventSource=\"Application\" eventID=\"1011\"][examplePriority@32473 class=\"high\"]")), "127.0.0.1 " + "<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut=\"3\" eventSource=\"Application\" eventID=\"1011\"][examplePriority@32473 class=\"high\"]"},
@@ -348,3 +428,145 @@ func TestMessage_StructuredDataPopulated(t *testing.T) {
 		})
 	}
 }
+
+func TestMessage_StructuredDataLookup(t *testing.T) {
+	m := mbsyslog.NewMessage(nil, []byte("<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [origin ip=\"192.0.2.1\" ip=\"192.0.2.2\"][meta sequenceId=\"42\" note=\"say \\\"hi\\\"\"][empty]"))
+	tests := []struct {
+		name    string
+		id      string
+		exists  bool
+		param   string
+		want    string
+		wantAll []string
+		found   bool
+	}{
+		{"Single", "meta", true, "sequenceId", "42", []string{"42"}, true},
+		{"Repeated", "origin", true, "ip", "192.0.2.1", []string{"192.0.2.1", "192.0.2.2"}, true},
+		{"Escaped", "meta", true, "note", "say \"hi\"", []string{"say \"hi\""}, true},
+		{"MissingParameter", "meta", true, "sysUpTime", "", nil, false},
+		{"NoParameters", "empty", true, "ip", "", nil, false},
+		{"MissingElement", "timeQuality", false, "ip", "", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, ok := m.StructuredData().Lookup(tt.id)
+			if ok != tt.exists {
+				t.Fatalf("Message.StructuredData().Lookup(%q) found = %v, want %v", tt.id, ok, tt.exists)
+			}
+			if ok && e.ID() != tt.id {
+				t.Errorf("Message.StructuredData().Lookup(%q).ID() = %s", tt.id, e.ID())
+			}
+			if got, found := e.Get(tt.param); got != tt.want || found != tt.found {
+				t.Errorf("Element.Get(%q) = %q, %v, want %q, %v", tt.param, got, found, tt.want, tt.found)
+			}
+			if got := e.GetAll(tt.param); !reflect.DeepEqual(got, tt.wantAll) {
+				t.Errorf("Element.GetAll(%q) = %v, want %v", tt.param, got, tt.wantAll)
+			}
+		})
+	}
+}
+
+func TestMessage_StructuredDataIterate(t *testing.T) {
+	m := mbsyslog.NewMessage(nil, []byte("<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [origin ip=\"192.0.2.1\" ip=\"192.0.2.2\"][meta sequenceId=\"42\"][empty]"))
+
+	var got []string
+	for e := range m.StructuredData().All() {
+		got = append(got, e.ID())
+		for name, value := range e.All() {
+			got = append(got, name+"="+value)
+		}
+	}
+	want := []string{"origin", "ip=192.0.2.1", "ip=192.0.2.2", "meta", "sequenceId=42", "empty"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Message.StructuredData().All() = %v, want %v", got, want)
+	}
+
+	//stopping early mustn't yield any more
+	count := 0
+	for range m.StructuredData().All() {
+		count++
+		break
+	}
+	if count != 1 {
+		t.Errorf("Message.StructuredData().All() yielded %d elements after break, want 1", count)
+	}
+
+	wantMap := map[string]map[string][]string{
+		"origin": {"ip": {"192.0.2.1", "192.0.2.2"}},
+		"meta":   {"sequenceId": {"42"}},
+		"empty":  {},
+	}
+	if got := m.StructuredData().Map(); !reflect.DeepEqual(got, wantMap) {
+		t.Errorf("Message.StructuredData().Map() = %v, want %v", got, wantMap)
+	}
+}
+
+func TestMessage_MarshalRFC5424(t *testing.T) {
+	tests := []struct {
+		name    string
+		m       mbsyslog.Message
+		want    string
+		wantErr bool
+	}{
+		{"RFC5424Valid1", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - BOM'su root' failed for lonvick on /dev/pts/8")), "<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - \xEF\xBB\xBF'su root' failed for lonvick on /dev/pts/8", false},
+		{"RFC5424Valid2", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<165>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 myproc 8710 - - %% It's time to make the do-nuts.")), "<165>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 myproc 8710 - - \xEF\xBB\xBF%% It's time to make the do-nuts.", false},
+		{"RFC5424Valid4", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut=\"3\" eventSource=\"Application\" eventID=\"1011\"][examplePriority@32473 class=\"high\"]")), "<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut=\"3\" eventSource=\"Application\" eventID=\"1011\"][examplePriority@32473 class=\"high\"]", false},
+		{"RFC5424Escaped", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<165>1 2003-10-11T22:14:15.003Z host app - - [id@32473 path=\"C:\\\\temp\" quote=\"say \\\"hi\\\"\" bracket=\"[a\\]\"] content")), "<165>1 2003-10-11T22:14:15.003Z host app - - [id@32473 path=\"C:\\\\temp\" quote=\"say \\\"hi\\\"\" bracket=\"[a\\]\"] \xEF\xBB\xBFcontent", false},
+		{"RFC5424NotUTF8", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<165>1 - - - - - - \xFF\xFE")), "<165>1 - - - - - - \xFF\xFE", false},
+		{"SimpleValid", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<151>The quick brown fox jumps over the lazy dog")), "<151>1 - - - - - - \xEF\xBB\xBFThe quick brown fox jumps over the lazy dog", false},
+		{"RFC3164Valid", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<3>Nov 10 14:38:52 machineName appName The quick brown fox jumps over the lazy dog")), "<3>1 " + bsdDate(time.November, 10, 14, 38, 52).Format(time.RFC3339) + " machineName appName - - - \xEF\xBB\xBFThe quick brown fox jumps over the lazy dog", false},
+		{"InvalidPriority", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<192>The quick brown fox jumps over the lazy dog")), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.m.MarshalRFC5424()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Message.MarshalRFC5424() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Message.MarshalRFC5424() = %q, want %q", got, tt.want)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			//parsing the output gives back the same message
+			parsed := mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, got)
+			if parsed.Format() != mbsyslog.MessageFormatRFC5424 {
+				t.Errorf("Message.MarshalRFC5424() output parsed as %s", parsed.Format())
+			}
+			if !reflect.DeepEqual(parsed.StructuredData(), tt.m.StructuredData()) {
+				t.Errorf("Message.MarshalRFC5424() structured data = %v, want %v", parsed.StructuredData(), tt.m.StructuredData())
+			}
+			if parsed.Content() != tt.m.Content() {
+				t.Errorf("Message.MarshalRFC5424() content = %q, want %q", parsed.Content(), tt.m.Content())
+			}
+		})
+	}
+}
+
+func TestMessage_MarshalRFC3164(t *testing.T) {
+	tests := []struct {
+		name    string
+		m       mbsyslog.Message
+		want    string
+		wantErr bool
+	}{
+		{"RFC3164Valid", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<3>Nov 10 14:38:52 machineName appName The quick brown fox jumps over the lazy dog")), "<3>Nov 10 14:38:52 machineName appName: The quick brown fox jumps over the lazy dog", false},
+		{"RFC3164Tag", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<13>Nov  1 04:08:02 machineName su[1234]: The quick brown fox jumps over the lazy dog")), "<13>Nov  1 04:08:02 machineName su[1234]: The quick brown fox jumps over the lazy dog", false},
+		{"RFC5424Valid1", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - BOM'su root' failed for lonvick on /dev/pts/8")), "<34>Oct 11 22:14:15 mymachine.example.com su: 'su root' failed for lonvick on /dev/pts/8", false},
+		{"RFC5424NoHostname", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<165>1 2003-08-24T05:14:15.000003-07:00 - myproc 8710 - - %% It's time to make the do-nuts.")), "<165>Aug 24 05:14:15 127.0.0.1 myproc[8710]: %% It's time to make the do-nuts.", false},
+		{"InvalidPriority", *mbsyslog.NewMessage(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}, []byte("<192>1 2003-08-24T05:14:15.000003-07:00 - myproc 8710 - - %% It's time to make the do-nuts.")), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.m.MarshalRFC3164()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Message.MarshalRFC3164() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Message.MarshalRFC3164() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package mbsyslog
+
+//OverflowPolicy decides what a server does with a datagram when its queue of
+//messages waiting for a worker is full
+type OverflowPolicy int
+
+const (
+	//OverflowBlock stops reading datagrams until the queue has room. Datagrams
+	//that arrive meanwhile may be dropped by the operating system.
+	OverflowBlock OverflowPolicy = iota
+	//OverflowDropNewest drops the datagram that didn't fit in the queue
+	OverflowDropNewest
+	//OverflowDropOldest drops the datagram that has waited longest in the
+	//queue to make room for the new one
+	OverflowDropOldest
+	//OverflowDropBySeverity drops the datagram that didn't fit when its
+	//severity is less important than the overflow severity, and otherwise
+	//waits for room like OverflowBlock
+	OverflowDropBySeverity
+)
+
+//overflowPolicyCount is the number of overflow policies, for counting drops
+const overflowPolicyCount = 4
+
+//String returns the string representation of the OverflowPolicy
+func (op OverflowPolicy) String() string {
+	switch op {
+	case OverflowBlock:
+		return "OverflowBlock"
+	case OverflowDropNewest:
+		return "OverflowDropNewest"
+	case OverflowDropOldest:
+		return "OverflowDropOldest"
+	case OverflowDropBySeverity:
+		return "OverflowDropBySeverity"
+	default:
+		return "Unknown"
+	}
+}
@@ -0,0 +1,24 @@
+package mbsyslog
+
+import "testing"
+
+func TestOverflowPolicy_String(t *testing.T) {
+	tests := []struct {
+		name string
+		op   OverflowPolicy
+		want string
+	}{
+		{"OverflowBlock", OverflowBlock, "OverflowBlock"},
+		{"OverflowDropNewest", OverflowDropNewest, "OverflowDropNewest"},
+		{"OverflowDropOldest", OverflowDropOldest, "OverflowDropOldest"},
+		{"OverflowDropBySeverity", OverflowDropBySeverity, "OverflowDropBySeverity"},
+		{"OverflowUnknown", 392, "Unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.op.String(); got != tt.want {
+				t.Errorf("OverflowPolicy.String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package mbsyslog
+
+import "strconv"
+
+//ParseError describes where a message didn't follow the expected format. Field
+//is one of "priority", "version", "timestamp", "hostname", "application",
+//"process ID", "message ID", "structured data", "content" or "message" when
+//the problem is with the message as a whole. Offset is the position in the
+//data where the field starts or the problem was found.
+type ParseError struct {
+	Field  string
+	Offset int
+	Err    error
+}
+
+//Error returns the description of the parse failure
+func (e *ParseError) Error() string {
+	return "Failed to parse " + e.Field + " at offset " + strconv.Itoa(e.Offset) + ": " + e.Err.Error()
+}
+
+//Unwrap returns the reason the field failed to parse
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,393 @@
+package mbsyslog
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+//maxRFC3164Length is the largest message RFC 3164 allows
+const maxRFC3164Length = 1024
+
+//ParseOptions controls how ParseMessage reads a message
+type ParseOptions struct {
+	//Mode selects how strictly the format is checked
+	Mode ParseMode
+	//Source is the address the message was received from, which may be nil
+	Source net.Addr
+	//ReceivedAt is when the message was received, used to infer the year of
+	//RFC 3164 timestamps. The default is the current time.
+	ReceivedAt time.Time
+	//Location is the time zone of RFC 3164 timestamps, which don't include
+	//one. The default is UTC.
+	Location *time.Location
+	//SourceLocation returns the time zone of RFC 3164 timestamps sent by the
+	//source, for networks where devices are set to different time zones. When
+	//it is nil or returns nil, Location is used.
+	SourceLocation func(source net.Addr) *time.Location
+	//Local is set for messages from a Unix socket, which glibc syslog(3)
+	//sends in the RFC 3164 format without the hostname. The server sets it
+	//for its Unix socket listeners.
+	Local bool
+}
+
+//maxClockSkew is how far ahead of the receive time an RFC 3164 timestamp may
+//be before it is taken to be from the year before
+const maxClockSkew = 24 * time.Hour
+
+//bsdTimestamp completes an RFC 3164 timestamp with the time zone and year.
+//The year is the latest one that doesn't put the timestamp in the future, so a
+//delayed or replayed message is dated the year before. A clock running ahead
+//by up to maxClockSkew is allowed, so a January message received just before
+//New Year is dated the year after.
+func (o ParseOptions) bsdTimestamp(date time.Time) time.Time {
+	location := o.Location
+	if o.SourceLocation != nil {
+		if sourceLocation := o.SourceLocation(o.Source); sourceLocation != nil {
+			location = sourceLocation
+		}
+	}
+	if location == nil {
+		location = time.UTC
+	}
+	receivedAt := o.ReceivedAt
+	if receivedAt.IsZero() {
+		receivedAt = time.Now()
+	}
+
+	latest := receivedAt.Add(maxClockSkew)
+	result := time.Date(latest.In(location).Year(), date.Month(), date.Day(), date.Hour(), date.Minute(), date.Second(), date.Nanosecond(), location)
+	if result.After(latest) {
+		result = result.AddDate(-1, 0, 0)
+	}
+	return result
+}
+
+//ParseMessage parses a syslog message. In the tolerant mode every message is
+//accepted, the same as NewMessage. In the RFC 5424 and RFC 3164 modes a
+//*ParseError is returned, naming the field that broke the format and its
+//offset in the data.
+func ParseMessage(data []byte, options ParseOptions) (*Message, error) {
+	result := new(Message)
+	result.source = options.Source
+
+	var err error
+	switch options.Mode {
+	case ParseModeRFC5424:
+		err = result.parseStrictRFC5424(string(data))
+	case ParseModeRFC3164:
+		err = result.parseStrictRFC3164(string(data), options)
+	default:
+		result.parse(string(data), options)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+//strictParser walks through a message one field at a time, failing with the
+//field name and the offset of the problem
+type strictParser struct {
+	raw   string
+	index int
+}
+
+func (p *strictParser) fail(field string, offset int, reason string) error {
+	return &ParseError{Field: field, Offset: offset, Err: errors.New(reason)}
+}
+
+//token returns the data up to the next space or the end of the message
+func (p *strictParser) token() string {
+	start := p.index
+	end := strings.IndexByte(p.raw[start:], ' ')
+	if end == -1 {
+		p.index = len(p.raw)
+	} else {
+		p.index = start + end
+	}
+	return p.raw[start:p.index]
+}
+
+//space consumes the single space that separates the field from the next one
+func (p *strictParser) space(field string) error {
+	if p.index >= len(p.raw) || p.raw[p.index] != ' ' {
+		return p.fail(field, p.index, "Missing space after field")
+	}
+	p.index++
+	return nil
+}
+
+//headerField reads a field that is either the NILVALUE dash or printable
+//US-ASCII up to the maximum length, returning the empty string for NILVALUE
+func (p *strictParser) headerField(field string, maxLength int) (string, error) {
+	start := p.index
+	value := p.token()
+	if value == "-" {
+		return "", p.space(field)
+	}
+	if !isPrintASCII(value, maxLength) {
+		return "", p.fail(field, start, "Must be 1 to "+strconv.Itoa(maxLength)+" printable US-ASCII characters")
+	}
+	return value, p.space(field)
+}
+
+func (p *strictParser) priority() (int, error) {
+	end := strings.IndexByte(p.raw, '>')
+	if len(p.raw) == 0 || p.raw[0] != '<' || end < 2 || end > 4 {
+		return 0, p.fail("priority", 0, "Must be 1 to 3 digits between < and >")
+	}
+
+	value := p.raw[1:end]
+	priority, err := strconv.Atoi(value)
+	if err != nil || value[0] == '+' || value[0] == '-' {
+		return 0, p.fail("priority", 1, "Must be 1 to 3 digits between < and >")
+	}
+	if priority > maxPriority || (len(value) > 1 && value[0] == '0') {
+		return 0, p.fail("priority", 1, "Must be between 0 and 191 without leading zeros")
+	}
+
+	p.index = end + 1
+	return priority, nil
+}
+
+//parseStrictRFC5424 parses the message following the RFC 5424 ABNF:
+//
+//	<PRI>VERSION SP TIMESTAMP SP HOSTNAME SP APP-NAME SP PROCID SP MSGID SP STRUCTURED-DATA [SP MSG]
+func (m *Message) parseStrictRFC5424(data string) error {
+	var err error
+	p := &strictParser{raw: data}
+
+	m.raw = data
+	m.format = MessageFormatRFC5424
+	m.processID = -1
+
+	if m.priority, err = p.priority(); err != nil {
+		return err
+	}
+
+	start := p.index
+	if p.token() != "1" {
+		return p.fail("version", start, "Must be 1")
+	}
+	m.version = 1
+	if err = p.space("version"); err != nil {
+		return err
+	}
+
+	start = p.index
+	if timestamp := p.token(); timestamp != "-" {
+		if m.date, err = parseRFC5424Timestamp(timestamp); err != nil {
+			return &ParseError{Field: "timestamp", Offset: start, Err: err}
+		}
+	}
+	if err = p.space("timestamp"); err != nil {
+		return err
+	}
+
+	if m.hostname, err = p.headerField("hostname", 255); err != nil {
+		return err
+	}
+	if m.application, err = p.headerField("application", 48); err != nil {
+		return err
+	}
+
+	//RFC 5424 allows any text for the process ID, but it is only kept when it
+	//is a number
+	processID, err := p.headerField("process ID", 128)
+	if err != nil {
+		return err
+	}
+	if value, err := strconv.Atoi(processID); err == nil && value >= 0 {
+		m.processID = value
+	}
+
+	if m.messageID, err = p.headerField("message ID", 32); err != nil {
+		return err
+	}
+
+	if err = m.parseStrictStructuredData(p); err != nil {
+		return err
+	}
+
+	//the content is optional, but must be separated by a space when present
+	if p.index == len(data) {
+		return nil
+	}
+	if err = p.space("structured data"); err != nil {
+		return err
+	}
+	m.content = data[p.index:]
+	if strings.HasPrefix(m.content, utf8BOM) {
+		m.content = m.content[len(utf8BOM):]
+		if !utf8.ValidString(m.content) {
+			return p.fail("content", p.index, "Content after the BOM must be UTF-8")
+		}
+	}
+	return nil
+}
+
+//parseStrictStructuredData parses the elements, requiring escaped characters
+//in parameter values and valid names
+func (m *Message) parseStrictStructuredData(p *strictParser) error {
+	if p.index < len(p.raw) && p.raw[p.index] == '-' {
+		p.index++
+		return nil
+	}
+	if p.index >= len(p.raw) || p.raw[p.index] != '[' {
+		return p.fail("structured data", p.index, "Must be - or elements in brackets")
+	}
+
+	for p.index < len(p.raw) && p.raw[p.index] == '[' {
+		start := p.index
+		end := findElementEnd(p.raw, start+1)
+		if end == -1 {
+			return p.fail("structured data", start, "Element is missing the closing bracket")
+		}
+
+		var e *Element
+		err := strictElement(p.raw[start+1 : end])
+		if err == nil {
+			e, err = parseElement(p.raw[start+1:end], true)
+		}
+		if err == nil {
+			err = e.validate()
+		}
+		if err != nil {
+			return &ParseError{Field: "structured data", Offset: start, Err: err}
+		}
+
+		m.structuredData.elements = append(m.structuredData.elements, e)
+		p.index = end + 1
+	}
+
+	if err := m.structuredData.validate(); err != nil {
+		return &ParseError{Field: "structured data", Offset: p.index, Err: err}
+	}
+	return nil
+}
+
+//strictElement checks the id and parameters of the element between the
+//brackets are separated by single spaces, and that quotes and closing brackets
+//in parameter values are escaped. A backslash before any other character is a
+//literal backslash, as RFC 5424 section 6.3.3 requires.
+func strictElement(raw string) error {
+	index := strings.IndexByte(raw, ' ')
+	if index == -1 {
+		return nil
+	}
+
+	for index < len(raw) {
+		if raw[index] != ' ' {
+			return errors.New("Parameters must be separated by a single space")
+		}
+		index++
+		equals := strings.IndexByte(raw[index:], '=')
+		if equals == -1 || index+equals+1 >= len(raw) || raw[index+equals+1] != '"' {
+			return errors.New("Parameter value must be quoted")
+		}
+
+		for index += equals + 2; index < len(raw) && raw[index] != '"'; index++ {
+			switch raw[index] {
+			case '\\':
+				if index+1 == len(raw) {
+					return errors.New("Parameter value ends with a lone backslash")
+				}
+				index++ //skip the escaped character
+			case ']':
+				return errors.New("Closing bracket in parameter value must be escaped")
+			}
+		}
+		if index == len(raw) {
+			return errors.New("Parameter value is missing the closing quote")
+		}
+		index++
+	}
+	return nil
+}
+
+//parseStrictRFC3164 parses the message following RFC 3164 section 4.1:
+//
+//	<PRI>Mmm dd hh:mm:ss HOSTNAME TAG: CONTENT
+//
+//The tag is optional, and may include the process ID as in su[123]:. Local
+//messages have no hostname.
+func (m *Message) parseStrictRFC3164(data string, options ParseOptions) error {
+	var err error
+	p := &strictParser{raw: data}
+
+	m.raw = data
+	m.format = MessageFormatRFC3164
+	m.version = -1
+	m.processID = -1
+
+	if len(data) > maxRFC3164Length {
+		return p.fail("message", maxRFC3164Length, "Message is longer than 1024 bytes")
+	}
+	if m.priority, err = p.priority(); err != nil {
+		return err
+	}
+
+	start := p.index
+	if len(data) < start+len(time.Stamp) {
+		return p.fail("timestamp", start, "Must be in the form Mmm dd hh:mm:ss")
+	}
+	if m.date, err = time.Parse(time.Stamp, data[start:start+len(time.Stamp)]); err != nil || data[start+4] == '0' {
+		return p.fail("timestamp", start, "Must be in the form Mmm dd hh:mm:ss")
+	}
+	m.date = options.bsdTimestamp(m.date)
+	p.index += len(time.Stamp)
+	if err = p.space("timestamp"); err != nil {
+		return err
+	}
+
+	if !options.Local {
+		start = p.index
+		if m.hostname = p.token(); !isPrintASCII(m.hostname, 255) {
+			return p.fail("hostname", start, "Must be printable US-ASCII characters")
+		}
+		if err = p.space("hostname"); err != nil {
+			return err
+		}
+	}
+
+	//the tag ends with a colon, otherwise the rest is all content
+	start = p.index
+	tag := p.token()
+	if strings.HasSuffix(tag, ":") {
+		m.application = tag
+		m.parseTag()
+		if !isPrintASCII(m.application, 32) || strings.ContainsAny(m.application, "[]:") {
+			return p.fail("application", start, "Tag must be 1 to 32 characters")
+		}
+		if p.index < len(data) {
+			p.index++
+		}
+		start = p.index
+	}
+	m.content = data[start:]
+	return nil
+}
+
+//parseRFC5424Timestamp parses the RFC 3339 timestamp with the restrictions
+//of RFC 5424, upper case T and Z and at most 6 fractional digits
+func parseRFC5424Timestamp(value string) (time.Time, error) {
+	result, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, errors.New("Must be an RFC 3339 date and time")
+	}
+	if strings.ContainsAny(value, "tz") {
+		return time.Time{}, errors.New("T and Z must be upper case")
+	}
+	if dot := strings.IndexByte(value, '.'); dot != -1 {
+		digits := strings.IndexAny(value[dot:], "Z+-") - 1
+		if digits > 6 {
+			return time.Time{}, errors.New("Must have at most 6 fractional digits")
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,296 @@
+package mbsyslog_test
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/venutios/mbsyslog"
+)
+
+func TestParseMessage_RFC5424(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		hostname    string
+		application string
+		processID   int
+		messageID   string
+		elements    int
+		content     string
+	}{
+		{"Example1", "<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - \xEF\xBB\xBF'su root' failed for lonvick on /dev/pts/8", "mymachine.example.com", "su", -1, "ID47", 0, "'su root' failed for lonvick on /dev/pts/8"},
+		{"Example2", "<165>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 myproc 8710 - - %% It's time to make the do-nuts.", "192.0.2.1", "myproc", 8710, "", 0, "%% It's time to make the do-nuts."},
+		{"Example3", "<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut=\"3\" eventSource=\"Application\" eventID=\"1011\"] An application event log entry...", "mymachine.example.com", "evntslog", -1, "ID47", 1, "An application event log entry..."},
+		{"Example4", "<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut=\"3\" eventSource=\"Application\" eventID=\"1011\"][examplePriority@32473 class=\"high\"]", "mymachine.example.com", "evntslog", -1, "ID47", 2, ""},
+		{"AllNil", "<0>1 - - - - - -", "", "", -1, "", 0, ""},
+		{"TextProcessID", "<13>1 - host app worker-1 - - text", "host", "app", -1, "", 0, "text"},
+		{"EscapedValue", "<13>1 - host app - - [id@32473 value=\"a\\]b\\\"c\\\\d\"] text", "host", "app", -1, "", 1, "text"},
+		{"LiteralBackslash", "<13>1 - host app - - [ex@32473 path=\"C:\\temp\"] text", "host", "app", -1, "", 1, "text"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := mbsyslog.ParseMessage([]byte(tt.data), mbsyslog.ParseOptions{Mode: mbsyslog.ParseModeRFC5424})
+			if err != nil {
+				t.Fatalf("ParseMessage() error = %v", err)
+			}
+			if m.Format() != mbsyslog.MessageFormatRFC5424 || m.Version() != 1 {
+				t.Errorf("ParseMessage() format = %v, version = %v", m.Format(), m.Version())
+			}
+			if m.Hostname() != tt.hostname {
+				t.Errorf("Message.Hostname() = %v, want %v", m.Hostname(), tt.hostname)
+			}
+			if m.Application() != tt.application {
+				t.Errorf("Message.Application() = %v, want %v", m.Application(), tt.application)
+			}
+			if m.ProcessID() != tt.processID {
+				t.Errorf("Message.ProcessID() = %v, want %v", m.ProcessID(), tt.processID)
+			}
+			if m.MessageID() != tt.messageID {
+				t.Errorf("Message.MessageID() = %v, want %v", m.MessageID(), tt.messageID)
+			}
+			if m.StructuredData().Count() != tt.elements {
+				t.Errorf("StructuredData.Count() = %v, want %v", m.StructuredData().Count(), tt.elements)
+			}
+			if m.Content() != tt.content {
+				t.Errorf("Message.Content() = %v, want %v", m.Content(), tt.content)
+			}
+		})
+	}
+}
+
+func TestParseMessage_RFC5424LiteralBackslash(t *testing.T) {
+	//RFC 5424 section 6.3.3 treats a backslash before other characters as literal
+	m, err := mbsyslog.ParseMessage([]byte("<13>1 - host app - - [ex@32473 path=\"C:\\temp\" dir=\"a\\b\\\\c\"] text"), mbsyslog.ParseOptions{Mode: mbsyslog.ParseModeRFC5424})
+	if err != nil {
+		t.Fatalf("ParseMessage() error = %v", err)
+	}
+	e, _ := m.StructuredData().Lookup("ex@32473")
+	if got, _ := e.Get("path"); got != "C:\\temp" {
+		t.Errorf("Element.Get(path) = %q, want %q", got, "C:\\temp")
+	}
+	if got, _ := e.Get("dir"); got != "a\\b\\c" {
+		t.Errorf("Element.Get(dir) = %q, want %q", got, "a\\b\\c")
+	}
+}
+
+func TestParseMessage_RFC3164(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		hostname    string
+		application string
+		processID   int
+		content     string
+		local       bool
+	}{
+		{"Example1", "<34>Oct 11 22:14:15 mymachine su: 'su root' failed for lonvick on /dev/pts/8", "mymachine", "su", -1, "'su root' failed for lonvick on /dev/pts/8", false},
+		{"ProcessID", "<13>Feb  5 17:32:18 10.0.0.99 sshd[4123]: Accepted publickey", "10.0.0.99", "sshd", 4123, "Accepted publickey", false},
+		{"NoTag", "<13>Feb  5 17:32:18 10.0.0.99 Use the BFG!", "10.0.0.99", "", -1, "Use the BFG!", false},
+		{"Local", "<13>Feb  5 17:32:18 sshd[4123]: Accepted publickey", "", "sshd", 4123, "Accepted publickey", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := mbsyslog.ParseMessage([]byte(tt.data), mbsyslog.ParseOptions{Mode: mbsyslog.ParseModeRFC3164, Local: tt.local})
+			if err != nil {
+				t.Fatalf("ParseMessage() error = %v", err)
+			}
+			if m.Format() != mbsyslog.MessageFormatRFC3164 {
+				t.Errorf("Message.Format() = %v, want %v", m.Format(), mbsyslog.MessageFormatRFC3164)
+			}
+			if m.Hostname() != tt.hostname {
+				t.Errorf("Message.Hostname() = %v, want %v", m.Hostname(), tt.hostname)
+			}
+			if m.Application() != tt.application {
+				t.Errorf("Message.Application() = %v, want %v", m.Application(), tt.application)
+			}
+			if m.ProcessID() != tt.processID {
+				t.Errorf("Message.ProcessID() = %v, want %v", m.ProcessID(), tt.processID)
+			}
+			if m.Content() != tt.content {
+				t.Errorf("Message.Content() = %v, want %v", m.Content(), tt.content)
+			}
+		})
+	}
+}
+
+func TestParseMessage_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   mbsyslog.ParseMode
+		data   string
+		field  string
+		offset int
+	}{
+		{"Empty", mbsyslog.ParseModeRFC5424, "", "priority", 0},
+		{"MissingPriority", mbsyslog.ParseModeRFC5424, "1 - - - - - -", "priority", 0},
+		{"PriorityNotNumber", mbsyslog.ParseModeRFC5424, "<1a>1 - - - - - -", "priority", 1},
+		{"PriorityTooLarge", mbsyslog.ParseModeRFC5424, "<192>1 - - - - - -", "priority", 1},
+		{"PriorityLeadingZero", mbsyslog.ParseModeRFC5424, "<013>1 - - - - - -", "priority", 1},
+		{"Version", mbsyslog.ParseModeRFC5424, "<13>2 - - - - - -", "version", 4},
+		{"VersionMissing", mbsyslog.ParseModeRFC5424, "<13>", "version", 4},
+		{"Timestamp", mbsyslog.ParseModeRFC5424, "<13>1 2003-10-11 22:14:15 - - - - -", "timestamp", 6},
+		{"TimestampLowerCase", mbsyslog.ParseModeRFC5424, "<13>1 2003-10-11t22:14:15z - - - - -", "timestamp", 6},
+		{"TimestampPrecision", mbsyslog.ParseModeRFC5424, "<13>1 2003-10-11T22:14:15.0000001Z - - - - -", "timestamp", 6},
+		{"HostnameTooLong", mbsyslog.ParseModeRFC5424, "<13>1 - " + strings.Repeat("h", 256) + " - - - -", "hostname", 8},
+		{"ApplicationTooLong", mbsyslog.ParseModeRFC5424, "<13>1 - - " + strings.Repeat("a", 49) + " - - -", "application", 10},
+		{"MessageIDTooLong", mbsyslog.ParseModeRFC5424, "<13>1 - - - - " + strings.Repeat("m", 33) + " -", "message ID", 14},
+		{"ExtraSpace", mbsyslog.ParseModeRFC5424, "<13>1 -  - - - - -", "hostname", 8},
+		{"MissingStructuredData", mbsyslog.ParseModeRFC5424, "<13>1 - - - - -", "message ID", 15},
+		{"StructuredDataNotElement", mbsyslog.ParseModeRFC5424, "<13>1 - - - - - text", "structured data", 16},
+		{"StructuredDataUnclosed", mbsyslog.ParseModeRFC5424, "<13>1 - - - - - [id@32473 a=\"1\"", "structured data", 16},
+		{"StructuredDataUnescaped", mbsyslog.ParseModeRFC5424, "<13>1 - - - - - [id@32473 a=\"x]y\"]", "structured data", 16},
+		{"StructuredDataUnescapedQuote", mbsyslog.ParseModeRFC5424, "<13>1 - - - - - [id@32473 a=\"x\"y\"]", "structured data", 16},
+		{"StructuredDataSpaces", mbsyslog.ParseModeRFC5424, "<13>1 - - - - - [id@32473  a=\"1\"]", "structured data", 16},
+		{"StructuredDataDuplicate", mbsyslog.ParseModeRFC5424, "<13>1 - - - - - [id@32473][id@32473]", "structured data", 36},
+		{"ContentMissingSpace", mbsyslog.ParseModeRFC5424, "<13>1 - - - - - [id@32473]text", "structured data", 26},
+		{"ContentInvalidUTF8", mbsyslog.ParseModeRFC5424, "<13>1 - - - - - - \xEF\xBB\xBF\xFF", "content", 18},
+		{"RFC3164TooLong", mbsyslog.ParseModeRFC3164, "<13>Feb  5 17:32:18 host " + strings.Repeat("x", 1000), "message", 1024},
+		{"RFC3164Priority", mbsyslog.ParseModeRFC3164, "Feb  5 17:32:18 host text", "priority", 0},
+		{"RFC3164ZeroPaddedDay", mbsyslog.ParseModeRFC3164, "<13>Feb 05 17:32:18 host text", "timestamp", 4},
+		{"RFC3164Timestamp", mbsyslog.ParseModeRFC3164, "<13>2003-10-11T22:14:15Z host text", "timestamp", 4},
+		{"RFC3164Short", mbsyslog.ParseModeRFC3164, "<13>Feb  5", "timestamp", 4},
+		{"RFC3164Hostname", mbsyslog.ParseModeRFC3164, "<13>Feb  5 17:32:18  text", "hostname", 20},
+		{"RFC3164TagTooLong", mbsyslog.ParseModeRFC3164, "<13>Feb  5 17:32:18 host " + strings.Repeat("t", 33) + ": text", "application", 25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := mbsyslog.ParseMessage([]byte(tt.data), mbsyslog.ParseOptions{Mode: tt.mode})
+			if m != nil {
+				t.Errorf("ParseMessage() = %v, want nil", m)
+			}
+			var parseErr *mbsyslog.ParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("ParseMessage() error = %v, want *ParseError", err)
+			}
+			if parseErr.Field != tt.field || parseErr.Offset != tt.offset {
+				t.Errorf("ParseError = %v %v, want %v %v", parseErr.Field, parseErr.Offset, tt.field, tt.offset)
+			}
+		})
+	}
+}
+
+func TestParseMessage_Tolerant(t *testing.T) {
+	source := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 12345}
+	data := []byte("<13>Feb 05 17:32:18 host text")
+
+	m, err := mbsyslog.ParseMessage(data, mbsyslog.ParseOptions{Source: source})
+	if err != nil {
+		t.Fatalf("ParseMessage() error = %v", err)
+	}
+	want := mbsyslog.NewMessage(source, data)
+	if m.String() != want.String() || m.Format() != want.Format() || !m.Source().IP.Equal(source.IP) {
+		t.Errorf("ParseMessage() = %v, want %v", m, want)
+	}
+}
+
+func TestParseMessage_ModeFields(t *testing.T) {
+	//the tolerant mode keeps tags and parameter values as sent, the strict
+	//modes split the tag and unescape the values
+	tests := []struct {
+		name        string
+		data        string
+		mode        mbsyslog.ParseMode
+		application string
+		processID   int
+		value       string
+	}{
+		{"TolerantTag", "<13>Feb  5 17:32:18 host su[1234]: text", mbsyslog.ParseModeTolerant, "su[1234]:", -1, ""},
+		{"StrictTag", "<13>Feb  5 17:32:18 host su[1234]: text", mbsyslog.ParseModeRFC3164, "su", 1234, ""},
+		{"TolerantValue", "<13>1 - host app - - [ex@32473 quote=\"say \\\"hi\\\"\"] text", mbsyslog.ParseModeTolerant, "app", -1, "say \\\"hi\\\""},
+		{"StrictValue", "<13>1 - host app - - [ex@32473 quote=\"say \\\"hi\\\"\"] text", mbsyslog.ParseModeRFC5424, "app", -1, "say \"hi\""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := mbsyslog.ParseMessage([]byte(tt.data), mbsyslog.ParseOptions{Mode: tt.mode})
+			if err != nil {
+				t.Fatalf("ParseMessage() error = %v", err)
+			}
+			if m.Application() != tt.application || m.ProcessID() != tt.processID {
+				t.Errorf("Message.Application(), ProcessID() = %q, %d, want %q, %d", m.Application(), m.ProcessID(), tt.application, tt.processID)
+			}
+			e, _ := m.StructuredData().Lookup("ex@32473")
+			if tt.value != "" {
+				if got := e.Parameter(0).Value(); got != tt.value {
+					t.Errorf("Parameter.Value() = %q, want %q", got, tt.value)
+				}
+				//the lookups unescape the value in either mode
+				if got, _ := e.Get("quote"); got != "say \"hi\"" {
+					t.Errorf("Element.Get(quote) = %q, want %q", got, "say \"hi\"")
+				}
+			}
+
+			//either way the structured data is written back as it was sent
+			if got := m.StructuredData().String(); tt.value != "" && got != "[ex@32473 quote=\"say \\\"hi\\\"\"]" {
+				t.Errorf("StructuredData.String() = %q", got)
+			}
+		})
+	}
+}
+
+func TestParseError_Error(t *testing.T) {
+	_, err := mbsyslog.ParseMessage([]byte("<13>1 bad - - - - -"), mbsyslog.ParseOptions{Mode: mbsyslog.ParseModeRFC5424})
+	if err == nil {
+		t.Fatal("ParseMessage() error = nil")
+	}
+	want := "Failed to parse timestamp at offset 6: Must be an RFC 3339 date and time"
+	if err.Error() != want {
+		t.Errorf("ParseError.Error() = %v, want %v", err.Error(), want)
+	}
+	if errors.Unwrap(err) == nil {
+		t.Error("ParseError.Unwrap() = nil")
+	}
+}
+
+func TestParseMessage_BSDTimestamp(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		newYork = time.FixedZone("EST", -5*60*60)
+	}
+	tokyo := time.FixedZone("JST", 9*60*60)
+	remote := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 514}
+	sourceLocation := func(source net.Addr) *time.Location {
+		if addr, ok := source.(*net.UDPAddr); ok && addr.IP.Equal(remote.IP) {
+			return tokyo
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		options mbsyslog.ParseOptions
+		want    time.Time
+	}{
+		{"SameYear", "<13>Jun 15 12:00:00 host text", mbsyslog.ParseOptions{ReceivedAt: time.Date(2024, time.July, 1, 0, 0, 0, 0, time.UTC)}, time.Date(2024, time.June, 15, 12, 0, 0, 0, time.UTC)},
+		{"NewYear", "<13>Dec 31 23:59:58 host text", mbsyslog.ParseOptions{ReceivedAt: time.Date(2025, time.January, 1, 0, 0, 1, 0, time.UTC)}, time.Date(2024, time.December, 31, 23, 59, 58, 0, time.UTC)},
+		{"ClockSkew", "<13>Jan  1 00:00:05 host text", mbsyslog.ParseOptions{ReceivedAt: time.Date(2024, time.December, 31, 23, 59, 59, 0, time.UTC)}, time.Date(2025, time.January, 1, 0, 0, 5, 0, time.UTC)},
+		{"MidYearInJanuary", "<13>Jul  5 12:00:00 host text", mbsyslog.ParseOptions{ReceivedAt: time.Date(2025, time.January, 10, 0, 0, 0, 0, time.UTC)}, time.Date(2024, time.July, 5, 12, 0, 0, 0, time.UTC)},
+		{"DecemberInJanuary", "<13>Dec  1 12:00:00 host text", mbsyslog.ParseOptions{ReceivedAt: time.Date(2025, time.January, 10, 0, 0, 0, 0, time.UTC)}, time.Date(2024, time.December, 1, 12, 0, 0, 0, time.UTC)},
+		{"BeyondClockSkew", "<13>Jan  3 00:00:00 host text", mbsyslog.ParseOptions{ReceivedAt: time.Date(2024, time.December, 31, 12, 0, 0, 0, time.UTC)}, time.Date(2024, time.January, 3, 0, 0, 0, 0, time.UTC)},
+		{"SlightlyAhead", "<13>Mar  3 10:00:05 host text", mbsyslog.ParseOptions{ReceivedAt: time.Date(2024, time.March, 3, 10, 0, 0, 0, time.UTC)}, time.Date(2024, time.March, 3, 10, 0, 5, 0, time.UTC)},
+		{"Location", "<13>Jan  2 15:04:05 host text", mbsyslog.ParseOptions{ReceivedAt: time.Date(2024, time.July, 1, 0, 0, 0, 0, time.UTC), Location: newYork}, time.Date(2024, time.January, 2, 15, 4, 5, 0, newYork)},
+		{"LocationYear", "<13>Dec 31 20:00:00 host text", mbsyslog.ParseOptions{ReceivedAt: time.Date(2025, time.January, 1, 0, 30, 0, 0, time.UTC), Location: newYork}, time.Date(2024, time.December, 31, 20, 0, 0, 0, newYork)},
+		{"LocationBehindNewYear", "<13>Dec 31 23:59:00 host text", mbsyslog.ParseOptions{ReceivedAt: time.Date(2027, time.January, 1, 5, 30, 0, 0, time.UTC), Location: newYork}, time.Date(2026, time.December, 31, 23, 59, 0, 0, newYork)},
+		{"LocationAheadNewYear", "<13>Jan  1 00:01:00 host text", mbsyslog.ParseOptions{ReceivedAt: time.Date(2027, time.January, 1, 4, 0, 0, 0, time.UTC), Location: newYork}, time.Date(2027, time.January, 1, 0, 1, 0, 0, newYork)},
+		{"SourceLocation", "<13>Jan  2 15:04:05 host text", mbsyslog.ParseOptions{Source: remote, ReceivedAt: time.Date(2024, time.July, 1, 0, 0, 0, 0, time.UTC), Location: newYork, SourceLocation: sourceLocation}, time.Date(2024, time.January, 2, 15, 4, 5, 0, tokyo)},
+		{"SourceLocationDefault", "<13>Jan  2 15:04:05 host text", mbsyslog.ParseOptions{Source: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2)}, ReceivedAt: time.Date(2024, time.July, 1, 0, 0, 0, 0, time.UTC), Location: newYork, SourceLocation: sourceLocation}, time.Date(2024, time.January, 2, 15, 4, 5, 0, newYork)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, mode := range []mbsyslog.ParseMode{mbsyslog.ParseModeTolerant, mbsyslog.ParseModeRFC3164} {
+				options := tt.options
+				options.Mode = mode
+				m, err := mbsyslog.ParseMessage([]byte(tt.data), options)
+				if err != nil {
+					t.Fatalf("ParseMessage(%v) error = %v", mode, err)
+				}
+				if got := m.Date(); !got.Equal(tt.want) || got.Location().String() != tt.want.Location().String() {
+					t.Errorf("ParseMessage(%v) date = %v, want %v", mode, got, tt.want)
+				}
+			}
+		})
+	}
+}
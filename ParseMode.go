@@ -0,0 +1,32 @@
+package mbsyslog
+
+//ParseMode selects how strictly ParseMessage checks the message format
+type ParseMode int
+
+const (
+	//ParseModeTolerant parses any format and fills in the fields it finds, the
+	//same as NewMessage. RFC 3164 tags and structured data parameter values
+	//are kept as they were sent, though Element.Get and the other lookups
+	//return the values unescaped.
+	ParseModeTolerant ParseMode = iota
+	//ParseModeRFC5424 only accepts messages that follow RFC 5424, and
+	//unescapes structured data parameter values
+	ParseModeRFC5424
+	//ParseModeRFC3164 only accepts messages that follow RFC 3164, and splits
+	//the tag into the application and process ID
+	ParseModeRFC3164
+)
+
+//String returns the string representation of the ParseMode
+func (pm ParseMode) String() string {
+	switch pm {
+	case ParseModeTolerant:
+		return "ParseModeTolerant"
+	case ParseModeRFC5424:
+		return "ParseModeRFC5424"
+	case ParseModeRFC3164:
+		return "ParseModeRFC3164"
+	default:
+		return "Unknown"
+	}
+}
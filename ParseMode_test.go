@@ -0,0 +1,23 @@
+package mbsyslog
+
+import "testing"
+
+func TestParseMode_String(t *testing.T) {
+	tests := []struct {
+		name string
+		pm   ParseMode
+		want string
+	}{
+		{"ParseModeTolerant", ParseModeTolerant, "ParseModeTolerant"},
+		{"ParseModeRFC5424", ParseModeRFC5424, "ParseModeRFC5424"},
+		{"ParseModeRFC3164", ParseModeRFC3164, "ParseModeRFC3164"},
+		{"ParseModeUnknown", 392, "Unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.pm.String(); got != tt.want {
+				t.Errorf("ParseMode.String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
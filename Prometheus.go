@@ -0,0 +1,103 @@
+package mbsyslog
+
+import (
+	"bufio"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//metricFormats are the message formats reported as metrics, in order
+var metricFormats = []MessageFormat{MessageFormatUnknown, MessageFormatRFC3164, MessageFormatRFC5424, MessageFormatSimple}
+
+//metric is a counter reported for every listener and source
+type metric struct {
+	name  string
+	help  string
+	kind  string
+	value func(ReceiveStats) float64
+}
+
+var metrics = []metric{
+	{"received_datagrams_total", "Datagrams and stream frames received.", "counter", func(rs ReceiveStats) float64 { return float64(rs.Datagrams) }},
+	{"received_bytes_total", "Bytes of datagrams and stream frames received.", "counter", func(rs ReceiveStats) float64 { return float64(rs.Bytes) }},
+	{"truncated_total", "Messages truncated to the maximum message size.", "counter", func(rs ReceiveStats) float64 { return float64(rs.Truncated) }},
+	{"dropped_total", "Datagrams dropped because the queue was full.", "counter", func(rs ReceiveStats) float64 { return float64(rs.Dropped) }},
+	{"parse_failures_total", "Messages that failed strict parsing or had no known format.", "counter", func(rs ReceiveStats) float64 { return float64(rs.ParseFailures) }},
+	{"handler_errors_total", "Messages the handler returned an error for.", "counter", func(rs ReceiveStats) float64 { return float64(rs.HandlerErrors) }},
+}
+
+//MetricsHandler returns an HTTP handler serving the server statistics in the
+//Prometheus text exposition format. Every metric is reported per listener,
+//and with a "source_" prefix per source address:
+//
+//	http.Handle("/metrics", server.MetricsHandler())
+func (s *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writer := bufio.NewWriter(w)
+		stats := s.Stats()
+		writeMetrics(writer, "mbsyslog_", "listener", stats.Listeners)
+		writeMetrics(writer, "mbsyslog_source_", "source", stats.Sources)
+		writer.Flush()
+	})
+}
+
+//writeMetrics writes every metric family with one sample for each of the
+//statistics, labelled with the key
+func writeMetrics(w *bufio.Writer, prefix string, label string, stats map[string]ReceiveStats) {
+	keys := make([]string, 0, len(stats))
+	for key := range stats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, m := range metrics {
+		writeFamily(w, prefix+m.name, m.help, m.kind)
+		for _, key := range keys {
+			writeSample(w, prefix+m.name, m.value(stats[key]), label, key)
+		}
+	}
+
+	writeFamily(w, prefix+"messages_total", "Messages parsed by format.", "counter")
+	for _, key := range keys {
+		for _, format := range metricFormats {
+			name := strings.TrimPrefix(format.String(), "MessageFormat")
+			writeSample(w, prefix+"messages_total", float64(stats[key].Formats[format]), label, key, "format", name)
+		}
+	}
+
+	writeFamily(w, prefix+"handler_duration_seconds", "Time spent handling messages.", "summary")
+	for _, key := range keys {
+		writeSample(w, prefix+"handler_duration_seconds_sum", stats[key].HandlerTime.Seconds(), label, key)
+		writeSample(w, prefix+"handler_duration_seconds_count", float64(stats[key].HandlerCalls), label, key)
+	}
+}
+
+func writeFamily(w *bufio.Writer, name string, help string, kind string) {
+	w.WriteString("# HELP " + name + " " + help + "\n")
+	w.WriteString("# TYPE " + name + " " + kind + "\n")
+}
+
+//writeSample writes one line of a metric, with the labels given as name and
+//value pairs
+func writeSample(w *bufio.Writer, name string, value float64, labels ...string) {
+	w.WriteString(name)
+	w.WriteByte('{')
+	for index := 0; index+1 < len(labels); index += 2 {
+		if index > 0 {
+			w.WriteByte(',')
+		}
+		w.WriteString(labels[index] + "=\"" + escapeLabelValue(labels[index+1]) + "\"")
+	}
+	w.WriteString("} ")
+	w.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	w.WriteByte('\n')
+}
+
+//escapeLabelValue escapes the characters the exposition format doesn't allow
+//in label values
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(value)
+}
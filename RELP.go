@@ -0,0 +1,471 @@
+package mbsyslog
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	//maxRELPTransaction is the largest transaction number, after which the
+	//numbers start again at 1
+	maxRELPTransaction = 999999999
+	//maxRELPCommand limits the length of a RELP command name
+	maxRELPCommand = 32
+	//maxRELPResponse limits the size of the responses a client reads
+	maxRELPResponse = 4096
+	//relpAckTimeout is how long a client waits for an acknowledgment before
+	//treating the connection as broken
+	relpAckTimeout = 90 * time.Second
+	//maxRELPResends is how many times a message is sent again after a
+	//reconnect before the send fails
+	maxRELPResends = 3
+)
+
+//errRELPTooLarge is returned for a frame with more data than allowed. The data
+//has been skipped, so the frames after it can still be read.
+var errRELPTooLarge = errors.New("RELP frame too large")
+
+//relpOffers are the session parameters exchanged in the open command
+var relpOffers = []byte("relp_version=0\nrelp_software=mbsyslog\ncommands=syslog")
+
+//relpFrame is a RELP command or response:
+//
+//	TXNR SP COMMAND SP DATALEN [SP DATA] TRAILER
+type relpFrame struct {
+	txnr    int
+	command string
+	data    []byte
+}
+
+//readRELPFrame reads the next frame from the stream. If its data is larger
+//than the maximum size, the data is skipped and errRELPTooLarge is returned
+//with the transaction number and command of the frame.
+func readRELPFrame(r *bufio.Reader, maxSize int) (relpFrame, error) {
+	var result relpFrame
+	txnr, separator, err := readRELPNumber(r)
+	if err != nil {
+		return result, err
+	}
+	if separator != ' ' {
+		return result, errors.New("Malformed RELP transaction number")
+	}
+	result.txnr = txnr
+
+	command, err := r.ReadSlice(' ')
+	if err != nil || len(command) < 2 || len(command) > maxRELPCommand+1 {
+		return result, errors.New("Malformed RELP command")
+	}
+	result.command = string(command[:len(command)-1])
+
+	length, separator, err := readRELPNumber(r)
+	if err != nil {
+		return result, err
+	}
+	if length > 0 {
+		if separator != ' ' {
+			return result, errors.New("Malformed RELP data length")
+		}
+		if length > maxSize {
+			if _, err := r.Discard(length); err != nil {
+				return result, err
+			}
+		} else {
+			result.data = make([]byte, length)
+			if _, err := io.ReadFull(r, result.data); err != nil {
+				return result, err
+			}
+		}
+		separator, err = r.ReadByte()
+		if err != nil {
+			return result, err
+		}
+	} else if separator == ' ' {
+		//some senders put a space before the trailer of an empty frame
+		if separator, err = r.ReadByte(); err != nil {
+			return result, err
+		}
+	}
+	if separator != '\n' {
+		return result, errors.New("Missing RELP trailer")
+	}
+	if length > maxSize {
+		return result, fmt.Errorf("%w, %d bytes", errRELPTooLarge, length)
+	}
+	return result, nil
+}
+
+//readRELPNumber reads a number and the byte that ends it
+func readRELPNumber(r *bufio.Reader) (int, byte, error) {
+	result := 0
+	for digits := 0; ; digits++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, 0, err
+		}
+		if b < '0' || b > '9' {
+			if digits == 0 {
+				return 0, 0, errors.New("Malformed RELP number")
+			}
+			return result, b, nil
+		}
+		if digits == 9 {
+			return 0, 0, errors.New("RELP number too long")
+		}
+		result = result*10 + int(b-'0')
+	}
+}
+
+//appendRELPFrame adds the frame to the buffer
+func appendRELPFrame(buffer []byte, txnr int, command string, data []byte) []byte {
+	buffer = strconv.AppendInt(buffer, int64(txnr), 10)
+	buffer = append(buffer, ' ')
+	buffer = append(buffer, command...)
+	buffer = append(buffer, ' ')
+	buffer = strconv.AppendInt(buffer, int64(len(data)), 10)
+	if len(data) > 0 {
+		buffer = append(buffer, ' ')
+		buffer = append(buffer, data...)
+	}
+	return append(buffer, '\n')
+}
+
+//relpResult is the error of a response, or nil if it is "200 OK"
+func relpResult(response []byte) error {
+	if bytes.HasPrefix(response, []byte("200")) {
+		return nil
+	}
+	if line := bytes.IndexByte(response, '\n'); line >= 0 {
+		response = response[:line]
+	}
+	return fmt.Errorf("RELP server replied %q", response)
+}
+
+//WithRELPPort sets the port ServeRELP listens on, on the address set with
+//WithAddress, so RELP can be served next to plain TCP syslog. rsyslog usually
+//sends RELP to port 2514. By default ServeRELP uses the port or listener of
+//ServeTCP, so only one of them can run.
+func WithRELPPort(port int) ServerOption {
+	return func(s *Server) {
+		s.relpPort = port
+	}
+}
+
+//ListenRELP starts the server accepting syslog messages over RELP. It is the
+//same as ServeRELP with a background context, except nil is returned once the
+//server stops.
+func (s *Server) ListenRELP() error {
+	return ignoreServerClosed(s.ServeRELP(context.Background()))
+}
+
+//ServeRELP accepts syslog messages over the Reliable Event Logging Protocol,
+//as sent by the omrelp module of rsyslog, until the context is done or the
+//server is shut down. It listens on the same address and port as ServeTCP,
+//unless a port is set with WithRELPPort.
+//
+//Every message is acknowledged once the handler has returned. A handler error
+//is sent back as a failure, so the sender keeps the message. Messages that
+//fail strict parsing are acknowledged, as sending them again won't help.
+func (s *Server) ServeRELP(ctx context.Context) error {
+	listener, err := s.relpListener()
+	if err != nil {
+		return err
+	}
+
+	return s.serveStream(ctx, "relp", listener, s.readRELP, nil)
+}
+
+//relpListener opens the RELP port if one is set, and otherwise returns the
+//listener for stream connections
+func (s *Server) relpListener() (net.Listener, error) {
+	if s.relpPort == 0 {
+		return s.streamListener()
+	}
+	return net.Listen("tcp", net.JoinHostPort(s.address, strconv.Itoa(s.relpPort)))
+}
+
+//readRELP answers the commands on the connection until it is closed
+func (s *Server) readRELP(ctx context.Context, listener string, local bool, conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	var response []byte
+	opened := false
+	for {
+		f, err := readRELPFrame(reader, s.maxMessageSize)
+		if err != nil && !errors.Is(err, errRELPTooLarge) {
+			return
+		}
+
+		response = response[:0]
+		switch {
+		case err != nil:
+			//the sender would only send it again if the connection was dropped
+			response = appendRELPFrame(response, f.txnr, "rsp", []byte("500 "+err.Error()))
+		case f.command == "open":
+			opened = true
+			response = appendRELPFrame(response, f.txnr, "rsp", append([]byte("200 OK\n"), relpOffers...))
+		case f.command == "close":
+			response = appendRELPFrame(response, f.txnr, "rsp", nil)
+			response = appendRELPFrame(response, 0, "serverclose", nil)
+			conn.Write(response)
+			return
+		case !opened:
+			response = appendRELPFrame(response, f.txnr, "rsp", []byte("500 session not opened"))
+		case f.command == "syslog":
+			s.recordReceived(listener, conn.RemoteAddr(), len(f.data), false)
+			err := s.receive(ctx, datagram{listener: listener, local: local, source: conn.RemoteAddr(), data: f.data, receivedAt: time.Now()})
+			if err != nil {
+				response = appendRELPFrame(response, f.txnr, "rsp", []byte("500 "+err.Error()))
+			} else {
+				response = appendRELPFrame(response, f.txnr, "rsp", []byte("200 OK"))
+			}
+		default:
+			response = appendRELPFrame(response, f.txnr, "rsp", []byte("500 unknown command"))
+		}
+
+		if _, err := conn.Write(response); err != nil {
+			return
+		}
+	}
+}
+
+//relpSession is a RELP session with a single destination. Messages are
+//written as they are sent and each send waits for its acknowledgment. When
+//the connection breaks, the messages not yet acknowledged are sent again on a
+//new connection.
+type relpSession struct {
+	dial    func(ctx context.Context) (net.Conn, error)
+	mutex   sync.Mutex
+	conn    net.Conn
+	dialing chan struct{}
+	txnr    int
+	unacked []*relpMessage
+}
+
+//relpMessage is a message waiting for its acknowledgment
+type relpMessage struct {
+	data    []byte
+	txnr    int
+	resends int
+	done    chan error
+}
+
+func newRELPSession(dial func(ctx context.Context) (net.Conn, error)) *relpSession {
+	result := new(relpSession)
+	result.dial = dial
+	return result
+}
+
+//send writes the message and waits until the server acknowledges it or the
+//context is done
+func (s *relpSession) send(ctx context.Context, data []byte) error {
+	m := &relpMessage{data: data, done: make(chan error, 1)}
+
+	s.mutex.Lock()
+	if err := s.connect(ctx); err != nil {
+		s.mutex.Unlock()
+		return err
+	}
+	s.unacked = append(s.unacked, m)
+	s.transmit(m)
+	s.mutex.Unlock()
+
+	select {
+	case err := <-m.done:
+		return err
+	case <-ctx.Done():
+		s.mutex.Lock()
+		s.remove(m)
+		s.mutex.Unlock()
+		return ctx.Err()
+	}
+}
+
+//close ends the session, failing the messages still waiting
+func (s *relpSession) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.conn != nil {
+		s.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		s.conn.Write(appendRELPFrame(nil, s.nextTransaction(), "close", nil))
+		s.conn.Close()
+		s.conn = nil
+	}
+	for _, m := range s.unacked {
+		m.done <- errors.New("RELP session closed")
+	}
+	s.unacked = nil
+}
+
+//connect opens the connection and the session if there isn't one, then
+//sends the messages not yet acknowledged again. It is called with the mutex
+//held, which is released while dialing so other sends and acknowledgments
+//aren't held up. A caller finding a dial in progress waits for it to finish or
+//for its own context.
+func (s *relpSession) connect(ctx context.Context) error {
+	for s.conn == nil {
+		if dialing := s.dialing; dialing != nil {
+			s.mutex.Unlock()
+			select {
+			case <-dialing:
+			case <-ctx.Done():
+			}
+			s.mutex.Lock()
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		dialing := make(chan struct{})
+		s.dialing = dialing
+		s.mutex.Unlock()
+		conn, reader, err := s.open(ctx)
+		s.mutex.Lock()
+		s.dialing = nil
+		close(dialing)
+		if err != nil {
+			return err
+		}
+
+		s.conn = conn
+		s.txnr = 1
+		go s.read(conn, reader)
+
+		resend := s.unacked
+		s.unacked = nil
+		for _, m := range resend {
+			m.resends++
+			if m.resends > maxRELPResends {
+				m.done <- errors.New("RELP message not acknowledged after reconnecting")
+				continue
+			}
+			s.unacked = append(s.unacked, m)
+			s.transmit(m)
+		}
+	}
+	return nil
+}
+
+//open dials a connection and opens the session on it
+func (s *relpSession) open(ctx context.Context) (net.Conn, *bufio.Reader, error) {
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := bufio.NewReader(conn)
+	conn.SetDeadline(time.Now().Add(dialTimeout))
+	if _, err := conn.Write(appendRELPFrame(nil, 1, "open", relpOffers)); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	response, err := readRELPFrame(reader, maxRELPResponse)
+	if err == nil && (response.txnr != 1 || response.command != "rsp") {
+		err = errors.New("Unexpected RELP response to open")
+	}
+	if err == nil {
+		err = relpResult(response.data)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, reader, nil
+}
+
+//transmit writes the message with the next transaction number. If the write
+//fails the connection is closed, and the reader reconnects and sends it again.
+func (s *relpSession) transmit(m *relpMessage) {
+	m.txnr = s.nextTransaction()
+	s.conn.SetWriteDeadline(time.Now().Add(relpAckTimeout))
+	if _, err := s.conn.Write(appendRELPFrame(nil, m.txnr, "syslog", m.data)); err != nil {
+		s.conn.Close()
+		return
+	}
+	s.conn.SetReadDeadline(time.Now().Add(relpAckTimeout))
+}
+
+func (s *relpSession) nextTransaction() int {
+	s.txnr++
+	if s.txnr > maxRELPTransaction {
+		s.txnr = 1
+	}
+	return s.txnr
+}
+
+//read passes the acknowledgments on the connection to the messages waiting
+//for them. Once the connection breaks, it reconnects if messages are still
+//waiting, without holding up sends while it dials.
+func (s *relpSession) read(conn net.Conn, reader *bufio.Reader) {
+	for {
+		f, err := readRELPFrame(reader, maxRELPResponse)
+		if err != nil || f.command == "serverclose" {
+			break
+		}
+		if f.command == "rsp" {
+			s.acknowledge(conn, f)
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.conn != conn {
+		//closed by the client, or already replaced
+		return
+	}
+	conn.Close()
+	s.conn = nil
+	if len(s.unacked) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	if err := s.connect(ctx); err != nil {
+		for _, m := range s.unacked {
+			m.done <- err
+		}
+		s.unacked = nil
+	}
+}
+
+//acknowledge completes the message the response is for
+func (s *relpSession) acknowledge(conn net.Conn, f relpFrame) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.conn != conn {
+		return
+	}
+	for _, m := range s.unacked {
+		if m.txnr == f.txnr {
+			s.remove(m)
+			m.done <- relpResult(f.data)
+			break
+		}
+	}
+	if len(s.unacked) == 0 {
+		conn.SetReadDeadline(time.Time{})
+	} else {
+		conn.SetReadDeadline(time.Now().Add(relpAckTimeout))
+	}
+}
+
+//remove stops waiting for an acknowledgment of the message
+func (s *relpSession) remove(m *relpMessage) {
+	for index, waiting := range s.unacked {
+		if waiting == m {
+			s.unacked = append(s.unacked[:index], s.unacked[index+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package mbsyslog
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadRELPFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    relpFrame
+		wantErr bool
+	}{
+		{"Syslog", "2 syslog 11 <34>1 - - -\n", relpFrame{2, "syslog", []byte("<34>1 - - -")}, false},
+		{"Open", "1 open 14 relp_version=0\n", relpFrame{1, "open", []byte("relp_version=0")}, false},
+		{"DataWithTrailer", "3 syslog 5 a\nb\nc\n", relpFrame{3, "syslog", []byte("a\nb\nc")}, false},
+		{"Empty", "4 close 0\n", relpFrame{4, "close", nil}, false},
+		{"EmptyWithSpace", "0 serverclose 0 \n", relpFrame{0, "serverclose", nil}, false},
+		{"MissingTrailer", "2 syslog 3 abcd", relpFrame{}, true},
+		{"ShortData", "2 syslog 30 abc\n", relpFrame{}, true},
+		{"TooLarge", "2 syslog 200 " + strings.Repeat("a", 200) + "\n", relpFrame{}, true},
+		{"BadTransaction", "x syslog 1 a\n", relpFrame{}, true},
+		{"LongTransaction", "1234567890 syslog 1 a\n", relpFrame{}, true},
+		{"LongCommand", "1 " + strings.Repeat("c", 33) + " 1 a\n", relpFrame{}, true},
+		{"NoCommand", "1  1 a\n", relpFrame{}, true},
+		{"BadLength", "1 syslog x\n", relpFrame{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readRELPFrame(bufio.NewReader(strings.NewReader(tt.data)), 100)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readRELPFrame() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readRELPFrame() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppendRELPFrame(t *testing.T) {
+	if got := string(appendRELPFrame(nil, 7, "syslog", []byte("hello"))); got != "7 syslog 5 hello\n" {
+		t.Errorf("appendRELPFrame() = %q", got)
+	}
+	if got := string(appendRELPFrame(nil, 8, "close", nil)); got != "8 close 0\n" {
+		t.Errorf("appendRELPFrame() = %q", got)
+	}
+}
+
+//TestRELPSession_Resend checks a message is sent again on a new connection
+//when the first breaks before acknowledging it
+func TestRELPSession_Resend(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 5)
+	go func() {
+		for connection := 0; ; connection++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn, acknowledge bool) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					f, err := readRELPFrame(reader, 1024)
+					if err != nil {
+						return
+					}
+					switch f.command {
+					case "open":
+						conn.Write(appendRELPFrame(nil, f.txnr, "rsp", []byte("200 OK")))
+					case "syslog":
+						received <- string(f.data)
+						if !acknowledge {
+							//the connection breaks with the message in flight
+							return
+						}
+						conn.Write(appendRELPFrame(nil, f.txnr, "rsp", []byte("200 OK")))
+					}
+				}
+			}(conn, connection > 0)
+		}
+	}()
+
+	session := newRELPSession(func(ctx context.Context) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "tcp", listener.Addr().String())
+	})
+	defer session.close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := session.send(ctx, []byte("<34>1 - - - - - - in flight")); err != nil {
+		t.Fatalf("relpSession.send() error: %s", err)
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		select {
+		case data := <-received:
+			if data != "<34>1 - - - - - - in flight" {
+				t.Errorf("Server received %q", data)
+			}
+		default:
+			t.Fatalf("Server received the message %d times, want 2", attempt)
+		}
+	}
+}
+
+//TestRELPSession_SlowReconnect checks a send isn't held up past its context
+//while the reader is dialing a new connection
+func TestRELPSession_SlowReconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for connection := 0; ; connection++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn, acknowledge bool) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					f, err := readRELPFrame(reader, 1024)
+					if err != nil {
+						return
+					}
+					switch {
+					case f.command == "open":
+						conn.Write(appendRELPFrame(nil, f.txnr, "rsp", []byte("200 OK")))
+					case !acknowledge:
+						return
+					default:
+						conn.Write(appendRELPFrame(nil, f.txnr, "rsp", []byte("200 OK")))
+					}
+				}
+			}(conn, connection > 0)
+		}
+	}()
+
+	dials := 0
+	release := make(chan struct{})
+	session := newRELPSession(func(ctx context.Context) (net.Conn, error) {
+		dials++
+		if dials > 1 {
+			//the reconnect hangs until the test lets it through
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		return (&net.Dialer{}).DialContext(ctx, "tcp", listener.Addr().String())
+	})
+	defer session.close()
+
+	first := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		first <- session.send(ctx, []byte("<34>1 - - - - - - in flight"))
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		session.mutex.Lock()
+		reconnecting := session.dialing != nil
+		session.mutex.Unlock()
+		if reconnecting {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Session never reconnected")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := session.send(ctx, []byte("<34>1 - - - - - - impatient")); err != context.DeadlineExceeded {
+		t.Errorf("relpSession.send() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("relpSession.send() took %s during the reconnect", elapsed)
+	}
+
+	close(release)
+	if err := <-first; err != nil {
+		t.Errorf("relpSession.send() error after reconnecting: %s", err)
+	}
+}
@@ -0,0 +1,223 @@
+package mbsyslog
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//defaultReportInterval is how often a client reports the messages its rate
+//limit suppressed
+const defaultReportInterval = time.Minute
+
+//RateLimit is a token bucket limit on the messages a client sends. Messages
+//with MessageSeverityError and more important severities are never limited.
+type RateLimit struct {
+	//Rate is the number of messages allowed each second on average
+	Rate float64
+	//Burst is the number of messages allowed at once, at least 1
+	Burst int
+	//Sample sends one in every Sample messages over the limit, or none when
+	//it is 0
+	Sample int
+}
+
+//WithRateLimit limits the messages Send and the logging methods deliver,
+//counting every severity that doesn't have its own limit together. Messages
+//sent with SendData aren't limited.
+func WithRateLimit(limit RateLimit) ClientOption {
+	return func(c *Client) {
+		c.rateLimiter().all = newTokenBucket(limit)
+	}
+}
+
+//WithSeverityRateLimit gives messages with the severity their own limit,
+//separate from the limit set with WithRateLimit. Limits on MessageSeverityError
+//and more important severities are ignored.
+func WithSeverityRateLimit(severity MessageSeverity, limit RateLimit) ClientOption {
+	return func(c *Client) {
+		if severity > MessageSeverityError && severity <= MessageSeverityDebug {
+			c.rateLimiter().severities[severity] = newTokenBucket(limit)
+		}
+	}
+}
+
+//WithSuppressionReport sets how often the client sends a warning saying how
+//many messages the rate limit suppressed, when there were any. The default is
+//once a minute. Messages not yet reported are reported when the client is
+//closed, and messages suppressed after that are only counted.
+func WithSuppressionReport(interval time.Duration) ClientOption {
+	return func(c *Client) {
+		if interval > 0 {
+			c.rateLimiter().reportInterval = interval
+		}
+	}
+}
+
+//Suppressed returns the number of messages the rate limit has suppressed
+func (c *Client) Suppressed() uint64 {
+	if c.limiter == nil {
+		return 0
+	}
+
+	c.limiter.mutex.Lock()
+	defer c.limiter.mutex.Unlock()
+	return c.limiter.total
+}
+
+//rateLimiter returns the rate limiter of the client, creating it for the
+//first rate limit option
+func (c *Client) rateLimiter() *rateLimiter {
+	if c.limiter == nil {
+		c.limiter = new(rateLimiter)
+		c.limiter.reportInterval = defaultReportInterval
+	}
+	return c.limiter
+}
+
+//allow reports if a message with the severity is within the rate limit,
+//scheduling a report of the suppressed messages if it isn't
+func (c *Client) allow(severity MessageSeverity) bool {
+	if c.limiter == nil || severity <= MessageSeverityError {
+		return true
+	}
+
+	return c.limiter.allow(severity, time.Now(), c.reportSuppressed)
+}
+
+//closeReport stops the scheduled report and sends any suppressed messages
+//not yet reported, so nothing is sent once the client is closed
+func (c *Client) closeReport() {
+	c.limiter.stopReport()
+	c.reportSuppressed()
+}
+
+//reportSuppressed sends a warning with the number of messages suppressed
+//since the last report
+func (c *Client) reportSuppressed() {
+	count := c.limiter.takeSuppressed()
+	if count == 0 {
+		return
+	}
+
+	m, err := c.newMessageBuilder(MessageSeverityWarning).
+		Content(strconv.FormatUint(count, 10) + " messages suppressed by the rate limit").
+		Build()
+	if err == nil {
+		err = c.sendMessage(context.Background(), m)
+	}
+	if err != nil {
+		c.setAsyncError("", nil, err)
+	}
+}
+
+//rateLimiter keeps the token buckets of a client and counts the messages
+//they suppressed
+type rateLimiter struct {
+	mutex          sync.Mutex
+	all            *tokenBucket
+	severities     [MessageSeverityDebug + 1]*tokenBucket
+	reportInterval time.Duration
+	suppressed     uint64
+	total          uint64
+	reporting      bool
+	closed         bool
+	timer          *time.Timer
+	reports        sync.WaitGroup
+}
+
+//allow takes a token for the severity. The first message suppressed since the
+//last report schedules the report function to run once the report interval
+//has passed, unless reports have been stopped.
+func (l *rateLimiter) allow(severity MessageSeverity, now time.Time, report func()) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	bucket := l.severities[severity]
+	if bucket == nil {
+		bucket = l.all
+	}
+	if bucket == nil || bucket.allow(now) {
+		return true
+	}
+
+	l.suppressed++
+	l.total++
+	if !l.reporting && !l.closed {
+		l.reporting = true
+		l.reports.Add(1)
+		l.timer = time.AfterFunc(l.reportInterval, func() {
+			defer l.reports.Done()
+			report()
+		})
+	}
+	return false
+}
+
+//stopReport cancels the scheduled report, or waits for it to finish if it has
+//already started, and stops any more being scheduled
+func (l *rateLimiter) stopReport() {
+	l.mutex.Lock()
+	l.closed = true
+	if l.timer != nil && l.timer.Stop() {
+		l.reports.Done()
+	}
+	l.timer = nil
+	l.mutex.Unlock()
+
+	l.reports.Wait()
+}
+
+//takeSuppressed returns the messages suppressed since the last report and
+//starts counting again
+func (l *rateLimiter) takeSuppressed() uint64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	result := l.suppressed
+	l.suppressed = 0
+	l.reporting = false
+	return result
+}
+
+//tokenBucket allows messages at the rate, refilling up to the burst
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+	over   int
+}
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	result := new(tokenBucket)
+	result.limit = limit
+	result.tokens = float64(limit.Burst)
+	return result
+}
+
+//allow takes a token if there is one. Messages over the limit are sampled,
+//so every Sample message still gets through.
+func (b *tokenBucket) allow(now time.Time) bool {
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+		if b.tokens > float64(b.limit.Burst) {
+			b.tokens = float64(b.limit.Burst)
+		}
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true
+	}
+
+	b.over++
+	if b.limit.Sample > 0 && b.over%b.limit.Sample == 1%b.limit.Sample {
+		return true
+	}
+	return false
+}
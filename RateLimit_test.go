@@ -0,0 +1,65 @@
+package mbsyslog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_allow(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   RateLimit
+		offsets []time.Duration
+		want    []bool
+	}{
+		{"Burst", RateLimit{Rate: 1, Burst: 3}, []time.Duration{0, 0, 0, 0}, []bool{true, true, true, false}},
+		{"NoBurst", RateLimit{Rate: 1}, []time.Duration{0, 0}, []bool{true, false}},
+		{"Refill", RateLimit{Rate: 2, Burst: 1}, []time.Duration{0, 0, 500 * time.Millisecond, 600 * time.Millisecond}, []bool{true, false, true, false}},
+		{"RefillLimited", RateLimit{Rate: 10, Burst: 2}, []time.Duration{0, time.Hour, time.Hour, time.Hour}, []bool{true, true, true, false}},
+		{"Sample", RateLimit{Burst: 1, Sample: 3}, []time.Duration{0, 0, 0, 0, 0, 0}, []bool{true, true, false, false, true, false}},
+		{"SampleAll", RateLimit{Burst: 1, Sample: 1}, []time.Duration{0, 0, 0}, []bool{true, true, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTokenBucket(tt.limit)
+			start := time.Now()
+			for index, offset := range tt.offsets {
+				if got := b.allow(start.Add(offset)); got != tt.want[index] {
+					t.Errorf("tokenBucket.allow() message %d = %v, want %v", index, got, tt.want[index])
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiter_stopReport(t *testing.T) {
+	l := &rateLimiter{all: newTokenBucket(RateLimit{Burst: 1}), reportInterval: time.Millisecond}
+	reports := make(chan struct{}, 5)
+	report := func() { reports <- struct{}{} }
+	now := time.Now()
+
+	//the first suppressed message schedules a report
+	if !l.allow(MessageSeverityInformational, now, report) || l.allow(MessageSeverityInformational, now, report) {
+		t.Fatal("rateLimiter.allow() didn't suppress the second message")
+	}
+	if l.timer == nil {
+		t.Fatal("rateLimiter.allow() didn't schedule a report")
+	}
+
+	//once stopped, no report is scheduled for messages suppressed later
+	l.stopReport()
+	l.takeSuppressed()
+	for len(reports) > 0 {
+		<-reports
+	}
+	if l.allow(MessageSeverityInformational, now, report) {
+		t.Fatal("rateLimiter.allow() didn't suppress the message after stopping")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if l.timer != nil || len(reports) != 0 {
+		t.Error("rateLimiter.allow() scheduled a report after stopping")
+	}
+	if l.suppressed != 1 || l.total != 2 {
+		t.Errorf("rateLimiter counted %d, %d suppressed, want 1, 2", l.suppressed, l.total)
+	}
+}
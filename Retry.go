@@ -0,0 +1,56 @@
+package mbsyslog
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+//RetryPolicy decides how often and how quickly a client tries a message again
+//after sending it failed. The zero value tries every message once.
+type RetryPolicy struct {
+	//Attempts is the number of times a message is tried. With failover
+	//destinations every attempt tries each destination in turn.
+	Attempts int
+	//InitialBackoff is the wait before the second attempt
+	InitialBackoff time.Duration
+	//MaxBackoff limits the wait between attempts, or 0 for no limit
+	MaxBackoff time.Duration
+	//Multiplier grows the wait after each attempt. The default is 2.
+	Multiplier float64
+	//Jitter is the fraction of each wait that is randomized, between 0 and 1,
+	//so clients that failed together don't retry together
+	Jitter float64
+}
+
+//WithRetry sets how the client retries messages it failed to send
+func WithRetry(policy RetryPolicy) ClientOption {
+	return func(c *Client) {
+		c.retry = policy
+	}
+}
+
+//backoff returns the wait after the attempt, counting from 0
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	if p.InitialBackoff <= 0 {
+		return 0
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	//without a limit the wait grows past what a duration can hold
+	limit := float64(math.MaxInt64)
+	if p.MaxBackoff > 0 {
+		limit = float64(p.MaxBackoff)
+	}
+	wait := math.Min(float64(p.InitialBackoff)*math.Pow(multiplier, float64(attempt)), limit)
+	if jitter := math.Min(math.Max(p.Jitter, 0), 1); jitter > 0 {
+		wait -= wait * jitter * rand.Float64()
+	}
+	if wait >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(wait)
+}
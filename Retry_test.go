@@ -0,0 +1,37 @@
+package mbsyslog
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_backoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		p       RetryPolicy
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{"Zero", RetryPolicy{}, 3, 0, 0},
+		{"ZeroLate", RetryPolicy{}, 2000, 0, 0},
+		{"First", RetryPolicy{InitialBackoff: time.Second}, 0, time.Second, time.Second},
+		{"Doubles", RetryPolicy{InitialBackoff: time.Second}, 3, 8 * time.Second, 8 * time.Second},
+		{"Multiplier", RetryPolicy{InitialBackoff: time.Second, Multiplier: 3}, 2, 9 * time.Second, 9 * time.Second},
+		{"MaxBackoff", RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}, 10, 5 * time.Second, 5 * time.Second},
+		{"Unlimited", RetryPolicy{InitialBackoff: time.Second}, 100, math.MaxInt64, math.MaxInt64},
+		{"UnlimitedJitter", RetryPolicy{InitialBackoff: time.Second, Jitter: 0.5}, 1000, math.MaxInt64 / 2, math.MaxInt64},
+		{"Jitter", RetryPolicy{InitialBackoff: time.Second, Jitter: 0.5}, 1, time.Second, 2 * time.Second},
+		{"JitterLimited", RetryPolicy{InitialBackoff: time.Second, Jitter: 7}, 0, 0, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for index := 0; index < 100; index++ {
+				if got := tt.p.backoff(tt.attempt); got < tt.min || got > tt.max {
+					t.Fatalf("RetryPolicy.backoff() = %s, want between %s and %s", got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
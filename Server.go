@@ -1,7 +1,13 @@
 package mbsyslog
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -11,66 +17,546 @@ import (
 //the syslog system. Data is received and parsed into syslog messages.
 type Server struct {
 	maxMessageSize int
+	address        string
 	port           int
-	stopChan       chan struct{}
-	running        int32
-	messagesOut    chan<- Message
+	packetConn     net.PacketConn
+	listener       net.Listener
+	relpPort       int
+	parseOptions   ParseOptions
+	handler        Handler
+	stats          *statsRecorder
+	metricsHook    MetricsHook
+
+	mutex   *sync.Mutex
+	serving *sync.WaitGroup
+	running int32
+	closed  bool
+	done    chan struct{}
+	aborted context.Context
+	abort   context.CancelFunc
+
+	workers          int
+	queueSize        int
+	overflowPolicy   OverflowPolicy
+	overflowSeverity MessageSeverity
+	dropped          [overflowPolicyCount]uint64
+}
+
+//ErrServerClosed is returned by the Serve methods after the server has been
+//shut down
+var ErrServerClosed = errors.New("Server closed")
+
+//datagram is a received datagram, or a frame from a stream, waiting to be
+//parsed and handled
+type datagram struct {
+	listener   string
+	local      bool
+	source     net.Addr
+	peer       *Element
+	data       []byte
+	receivedAt time.Time
+}
+
+//datagramReader reads the next datagram from a socket into the buffer,
+//returning the size, the sender and the peer credentials if the socket has
+//them
+type datagramReader func(buffer []byte) (int, net.Addr, *Element, error)
+
+//ServerOption configures optional behaviour of a server
+type ServerOption func(*Server)
+
+//WithAddress sets the IP address or hostname of the interface the server
+//listens on. IPv6 addresses may be given with or without brackets. The
+//default is to listen on all IPv4 and IPv6 interfaces.
+func WithAddress(address string) ServerOption {
+	return func(s *Server) {
+		s.address = strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+	}
+}
+
+//WithPort sets the port the server listens on. The default is port 514.
+func WithPort(port int) ServerOption {
+	return func(s *Server) {
+		s.port = port
+	}
+}
+
+//WithMaxMessageSize sets the largest message the server accepts, larger
+//messages are truncated. The default is 8KB.
+func WithMaxMessageSize(size int) ServerOption {
+	return func(s *Server) {
+		s.maxMessageSize = size
+	}
+}
+
+//WithListenerConn makes Listen read datagrams from an existing connection
+//instead of opening a socket. The server closes the connection when it stops.
+func WithListenerConn(conn net.PacketConn) ServerOption {
+	return func(s *Server) {
+		s.packetConn = conn
+	}
+}
+
+//WithListener makes ListenTCP and ListenTLS accept connections from an
+//existing listener instead of opening a socket, as does ListenRELP without
+//WithRELPPort. The server closes the listener when it stops.
+func WithListener(listener net.Listener) ServerOption {
+	return func(s *Server) {
+		s.listener = listener
+	}
+}
+
+//WithParseOptions sets how received messages are parsed, such as the time zone
+//of RFC 3164 timestamps. The source and receive time are filled in for each
+//message. When a strict mode is set, messages that fail to parse are dropped.
+//The default is tolerant parsing with RFC 3164 timestamps in UTC.
+func WithParseOptions(options ParseOptions) ServerOption {
+	return func(s *Server) {
+		s.parseOptions = options
+	}
 }
 
-//NewServer prepares the server to listen for messages. The server will listen
-//on port 514 and have an 8KB maximum message size. The message channel will
-//receive all messages received. The channel should not be closed until the
-//server is not running by calling Running().
-func NewServer(messageChan chan<- Message) *Server {
+//WithWorkers sets how many datagrams Listen parses and handles at the same
+//time. The default is 16 workers. It applies to the UDP and Unix datagram
+//listeners, stream connections are each handled in order by the goroutine
+//reading them.
+func WithWorkers(workers int) ServerOption {
+	return func(s *Server) {
+		if workers < 1 {
+			workers = 1
+		}
+		s.workers = workers
+	}
+}
+
+//WithQueueSize sets how many datagrams Listen holds while waiting for a free
+//worker, before the overflow policy is applied. The default is 1024. Like the
+//workers, the queue only holds datagrams.
+func WithQueueSize(size int) ServerOption {
+	return func(s *Server) {
+		if size < 1 {
+			size = 1
+		}
+		s.queueSize = size
+	}
+}
+
+//WithOverflowPolicy sets what Listen does with a datagram when the queue is
+//full. The default is OverflowBlock. Messages on stream connections are never
+//dropped, a slow handler slows their senders down through flow control.
+func WithOverflowPolicy(policy OverflowPolicy) ServerOption {
+	return func(s *Server) {
+		s.overflowPolicy = policy
+	}
+}
+
+//WithOverflowSeverity sets the threshold for OverflowDropBySeverity. Datagrams
+//less important than the severity are dropped when the queue is full. The
+//default is MessageSeverityError, so warnings and below are dropped.
+func WithOverflowSeverity(severity MessageSeverity) ServerOption {
+	return func(s *Server) {
+		s.overflowSeverity = severity
+	}
+}
+
+//NewServer prepares the server to listen for messages. By default the server
+//will listen on port 514 of all IPv4 and IPv6 interfaces and have an 8KB
+//maximum message size. The message channel will receive all messages received. The channel
+//should not be closed until the server is not running by calling Running().
+func NewServer(messageChan chan<- Message, options ...ServerOption) *Server {
+	return NewHandlerServer(ChannelHandler(messageChan), options...)
+}
+
+//NewHandlerServer prepares the server to listen for messages, passing every
+//message received to the handler. The defaults are the same as NewServer.
+//Errors returned by the handler are counted in the statistics.
+func NewHandlerServer(handler Handler, options ...ServerOption) *Server {
 	result := new(Server)
+	result.address = ""
 	result.port = 514
 	result.maxMessageSize = 8192
-	result.messagesOut = messageChan
-	result.stopChan = make(chan struct{}, 1)
-	result.running = 0
+	result.handler = handler
+	result.stats = newStatsRecorder()
+	result.workers = 16
+	result.queueSize = 1024
+	result.overflowPolicy = OverflowBlock
+	result.overflowSeverity = MessageSeverityError
+	result.mutex = new(sync.Mutex)
+	result.serving = new(sync.WaitGroup)
+	result.done = make(chan struct{})
+	result.aborted, result.abort = context.WithCancel(context.Background())
+
+	for _, option := range options {
+		option(result)
+	}
 	return result
 }
 
-//Listen starts the server accepting syslog messages. The server will not stop
-//until the Stop() method is called, and all outstanding parsers have chance to
-//finish processing and write their message to the output channel.
+//Listen starts the server accepting syslog messages. It is the same as Serve
+//with a background context, except nil is returned once the server stops.
 func (s *Server) Listen() error {
+	return ignoreServerClosed(s.Serve(context.Background()))
+}
+
+//Serve accepts syslog messages over UDP until the context is done or the
+//server is shut down. Datagrams are queued and handled by a pool of workers,
+//see WithWorkers, WithQueueSize and WithOverflowPolicy. Serve returns once the
+//queued datagrams have been parsed and passed to the handler, with the context
+//error or ErrServerClosed.
+func (s *Server) Serve(ctx context.Context) error {
+	conn := s.packetConn
+	if conn == nil {
+		var err error
+		conn, err = net.ListenPacket("udp", s.listenAddress())
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.serveDatagrams(ctx, "udp "+conn.LocalAddr().String(), false, conn, func(buffer []byte) (int, net.Addr, *Element, error) {
+		count, addr, err := conn.ReadFrom(buffer)
+		return count, addr, nil, err
+	})
+}
+
+//serveDatagrams reads datagrams from the socket until the server is stopped,
+//queueing them for the workers. The listener names the socket in the
+//statistics, and local is set for Unix sockets.
+func (s *Server) serveDatagrams(ctx context.Context, listener string, local bool, socket io.Closer, read datagramReader) error {
+	if err := s.start(); err != nil {
+		socket.Close()
+		return err
+	}
+	defer s.finish()
+
+	handlerCtx, cancel := s.handlerContext(ctx)
+	defer cancel()
+
 	var wg sync.WaitGroup
+	queue := make(chan datagram, s.queueSize)
+	for worker := 0; worker < s.workers; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range queue {
+				s.receive(handlerCtx, d)
+			}
+		}()
+	}
+
+	w := s.watch(ctx, socket)
+	//defer evalaute as a stack, when stopping close the socket, close the
+	//queue so the workers finish what is left in it, and wait for the workers
+	defer wg.Wait()
+	defer close(queue)
+	defer socket.Close()
+	defer w.release()
+
+	//the extra byte shows when a datagram didn't fit and was truncated
+	buffer := make([]byte, s.maxMessageSize+1)
+	var delay time.Duration
+	for {
+		count, addr, peer, err := read(buffer)
+		if err != nil {
+			if reason := w.reason(); reason != nil { //supposed to stop, everything is deferred above
+				return reason
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+
+			//a socket that keeps failing would otherwise spin, so wait a little
+			//longer each time before reading again
+			delay = s.pause(ctx, delay)
+			continue
+		}
+		delay = 0
+
+		truncated := count > s.maxMessageSize
+		if truncated {
+			count = s.maxMessageSize
+		}
+		s.recordReceived(listener, addr, count, truncated)
+
+		//copy of the buffer, so it doesn't change while it is queued
+		data := make([]byte, count)
+		copy(data, buffer)
+		s.enqueue(queue, datagram{listener: listener, local: local, source: addr, peer: peer, data: data, receivedAt: time.Now()})
+	}
+}
+
+const (
+	//minErrorDelay is how long a listener first waits before reading or
+	//accepting again after an error
+	minErrorDelay = 5 * time.Millisecond
+	//maxErrorDelay limits the wait as the errors continue
+	maxErrorDelay = time.Second
+)
+
+//pause waits after a listener error, doubling the previous delay within the
+//limits, and returns the delay. It returns early when the server stops.
+func (s *Server) pause(ctx context.Context, delay time.Duration) time.Duration {
+	delay = min(max(2*delay, minErrorDelay), maxErrorDelay)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	case <-s.done:
+	}
+	return delay
+}
+
+//enqueue adds the datagram to the queue, applying the overflow policy when the
+//queue is full
+func (s *Server) enqueue(queue chan datagram, d datagram) {
+	select {
+	case queue <- d:
+		return
+	default:
+	}
+
+	switch s.overflowPolicy {
+	case OverflowDropNewest:
+		s.drop(d, OverflowDropNewest)
+		return
+	case OverflowDropOldest:
+		//the workers may take from the queue at the same time, so keep trying
+		//until the datagram fits
+		for {
+			select {
+			case oldest := <-queue:
+				s.drop(oldest, OverflowDropOldest)
+			default:
+			}
+			select {
+			case queue <- d:
+				return
+			default:
+			}
+		}
+	case OverflowDropBySeverity:
+		if severity, ok := datagramSeverity(d.data); !ok || severity > s.overflowSeverity {
+			s.drop(d, OverflowDropBySeverity)
+			return
+		}
+	}
+	queue <- d
+}
 
-	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: []byte{0, 0, 0, 0}, Port: s.port, Zone: ""})
+//datagramSeverity reads the severity from the priority at the start of the
+//datagram, without parsing the rest of the message
+func datagramSeverity(data []byte) (MessageSeverity, bool) {
+	if len(data) < 3 || data[0] != '<' {
+		return 0, false
+	}
+	priority := 0
+	for index := 1; index < len(data) && index <= 4; index++ {
+		if data[index] == '>' && index > 1 {
+			return MessageSeverity(priority % 8), true
+		}
+		if data[index] < '0' || data[index] > '9' {
+			return 0, false
+		}
+		priority = priority*10 + int(data[index]-'0')
+	}
+	return 0, false
+}
+
+func (s *Server) drop(d datagram, policy OverflowPolicy) {
+	atomic.AddUint64(&s.dropped[policy], 1)
+	s.recordDropped(d.listener, d.source, policy)
+}
+
+//ListenTLS starts the server accepting syslog messages over TLS. It is the
+//same as ServeTLS with a background context, except nil is returned once the
+//server stops.
+func (s *Server) ListenTLS(config *tls.Config) error {
+	return ignoreServerClosed(s.ServeTLS(context.Background(), config))
+}
+
+//ServeTLS accepts syslog messages over TLS (RFC 5425) until the context is
+//done or the server is shut down. Every connection carries octet counted
+//messages. Set ClientAuth and ClientCAs in the configuration to require client
+//certificates. Like Serve, it returns once the messages already received have
+//been handled.
+func (s *Server) ServeTLS(ctx context.Context, config *tls.Config) error {
+	listener, err := s.streamListener()
 	if err != nil {
 		return err
 	}
 
-	s.setRunning(true)
-	//defer evalaute as a stack, when stopping close the socket, wait for
-	//all gorountines to finish parsing, and then signal the server is stopped
-	defer func() { s.setRunning(false) }()
+	return s.serveStream(ctx, "tls", listener, s.framedReader(framingOctetCounting), func(conn net.Conn) net.Conn {
+		return tls.Server(conn, config)
+	})
+}
+
+//ListenTCP starts the server accepting syslog messages over TCP. It is the
+//same as ServeTCP with a background context, except nil is returned once the
+//server stops.
+func (s *Server) ListenTCP() error {
+	return ignoreServerClosed(s.ServeTCP(context.Background()))
+}
+
+//ServeTCP accepts syslog messages over TCP (RFC 6587) until the context is
+//done or the server is shut down. Each connection may use either octet
+//counting or non-transparent framing, which is detected from the first message
+//received on it. Like Serve, it returns once the messages already received
+//have been handled.
+func (s *Server) ServeTCP(ctx context.Context) error {
+	listener, err := s.streamListener()
+	if err != nil {
+		return err
+	}
+
+	return s.serveStream(ctx, "tcp", listener, s.framedReader(framingUnknown), nil)
+}
+
+//streamReader reads the messages from a connection until it is closed
+type streamReader func(ctx context.Context, listener string, local bool, conn net.Conn)
+
+//serveStream accepts connections until the server is stopped, reading
+//messages from each connection concurrently. Each connection passes its
+//messages to the handler in order, without the worker queue used for
+//datagrams. The network names the listener
+//in the statistics. The wrap function, if not nil, is applied to every
+//accepted connection.
+func (s *Server) serveStream(ctx context.Context, network string, listener net.Listener, read streamReader, wrap func(net.Conn) net.Conn) error {
+	if err := s.start(); err != nil {
+		listener.Close()
+		return err
+	}
+	defer s.finish()
+
+	handlerCtx, cancel := s.handlerContext(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	conns := make(map[net.Conn]struct{})
+	name := network + " " + listener.Addr().String()
+	local := network == "unix"
+
+	w := s.watch(ctx, listener)
+	//defer evaluate as a stack, when stopping close the listener, close the
+	//open connections so their readers return, and wait for the readers to
+	//finish
 	defer wg.Wait()
-	defer conn.Close()
+	defer func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		for conn := range conns {
+			conn.Close()
+		}
+	}()
+	defer listener.Close()
+	defer w.release()
 
-	buffer := make([]byte, s.maxMessageSize)
+	var delay time.Duration
 	for {
-		select {
-		case <-s.stopChan: //supposed to stop, everything is deferred above
-			return nil
-		default:
-			conn.SetDeadline(time.Now().Add(1 * time.Second))
-			count, addr, err := conn.ReadFromUDP(buffer)
-			if err == nil {
-				//copy of the buffer, so it doesn't change while the goroutine runs
-				data := make([]byte, count)
-				copy(data, buffer)
-				wg.Add(1)
-				go func(data []byte) {
-					defer wg.Done()
-					s.messagesOut <- *NewMessage(addr, data)
-				}(data)
+		conn, err := listener.Accept()
+		if err != nil {
+			if reason := w.reason(); reason != nil { //supposed to stop, everything is deferred above
+				return reason
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
 			}
+
+			//other errors, such as running out of file descriptors, may pass, so
+			//wait a little longer each time before accepting again
+			delay = s.pause(ctx, delay)
+			continue
+		}
+		delay = 0
+
+		if wrap != nil {
+			conn = wrap(conn)
+		}
+		mutex.Lock()
+		conns[conn] = struct{}{}
+		mutex.Unlock()
+
+		wg.Add(1)
+		go func(conn net.Conn) {
+			defer wg.Done()
+			defer func() {
+				mutex.Lock()
+				defer mutex.Unlock()
+				delete(conns, conn)
+				conn.Close()
+			}()
+			read(handlerCtx, name, local, conn)
+		}(conn)
+	}
+}
+
+//framedReader returns a stream reader for messages with the framing
+func (s *Server) framedReader(f framing) streamReader {
+	return func(ctx context.Context, listener string, local bool, conn net.Conn) {
+		s.readStream(ctx, listener, local, conn, f)
+	}
+}
+
+//readStream parses every message on the connection until it is closed
+func (s *Server) readStream(ctx context.Context, listener string, local bool, conn net.Conn, f framing) {
+	peer := connCredentials(conn)
+	reader := newFrameReader(conn, f, s.maxMessageSize)
+	for {
+		data, err := reader.readFrame()
+		if err != nil {
+			return
 		}
+		s.recordReceived(listener, conn.RemoteAddr(), len(data), reader.truncated)
+		s.receive(ctx, datagram{listener: listener, local: local, source: conn.RemoteAddr(), peer: peer, data: data, receivedAt: time.Now()})
 	}
 }
 
+//receive parses the data and passes the message to the handler, returning the
+//handler error. Messages that fail to parse in a strict mode are dropped.
+func (s *Server) receive(ctx context.Context, d datagram) error {
+	options := s.parseOptions
+	options.Source = d.source
+	options.ReceivedAt = d.receivedAt
+	options.Local = options.Local || d.local
+
+	m, err := ParseMessage(d.data, options)
+	if err != nil {
+		s.recordParsed(d.listener, d.source, MessageFormatUnknown, err)
+		return nil
+	}
+	s.recordParsed(d.listener, d.source, m.Format(), nil)
+	if d.peer != nil {
+		m.structuredData.replaceElement(d.peer)
+	}
+
+	start := time.Now()
+	err = s.handler.HandleMessage(ctx, m)
+	s.recordHandled(d.listener, d.source, time.Since(start), err)
+	return err
+}
+
+//streamListener returns the listener for stream connections, opening a TCP
+//socket if one wasn't provided
+func (s *Server) streamListener() (net.Listener, error) {
+	if s.listener != nil {
+		return s.listener, nil
+	}
+	return net.Listen("tcp", s.listenAddress())
+}
+
+//listenAddress is the address and port to open sockets on. An empty address
+//opens a dual stack socket accepting both IPv4 and IPv6.
+func (s *Server) listenAddress() string {
+	return net.JoinHostPort(s.address, strconv.Itoa(s.port))
+}
+
+//Address that the server is configured to listen on, or the empty string for
+//all interfaces
+func (s Server) Address() string {
+	return s.address
+}
+
 //Port that the server is configured to listen on
 func (s Server) Port() int {
 	return s.port
@@ -81,23 +567,135 @@ func (s Server) MaximumMessageSize() int {
 	return s.maxMessageSize
 }
 
-//Stop signals the server to shutdown, but doesn't stop immediately
+//Stop signals every listener to stop, but doesn't wait for them. Use Shutdown
+//to wait until the messages already received have been handled. Stop can be
+//called more than once, and the server can't be started again afterwards.
 func (s *Server) Stop() {
-	s.stopChan <- *new(struct{})
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+}
+
+//Shutdown stops every listener and waits for the messages already received to
+//be handled. It returns nil when they were all handled, or the context error
+//when the context is done first, in which case the contexts passed to the
+//handler are canceled. Like Stop, Shutdown can be called more than once.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.Stop()
+
+	drained := make(chan struct{})
+	go func() {
+		s.serving.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		s.abort()
+		return ctx.Err()
+	}
+}
+
+//Dropped returns how many datagrams the overflow policy has dropped because
+//the queue was full. OverflowBlock never drops datagrams.
+func (s *Server) Dropped(policy OverflowPolicy) uint64 {
+	if policy < 0 || policy >= overflowPolicyCount {
+		return 0
+	}
+	return atomic.LoadUint64(&s.dropped[policy])
 }
 
 //Running returns if the server is currently accepting syslog messages or not
 func (s *Server) Running() bool {
-	if atomic.LoadInt32(&s.running) == 1 {
-		return true
+	return atomic.LoadInt32(&s.running) > 0
+}
+
+//start registers a listener that is about to serve, failing once the server
+//has been stopped
+func (s *Server) start() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+	s.serving.Add(1)
+	atomic.AddInt32(&s.running, 1)
+	return nil
+}
+
+//finish unregisters a listener once its messages have been handled
+func (s *Server) finish() {
+	atomic.AddInt32(&s.running, -1)
+	s.serving.Done()
+}
+
+//handlerContext is the context passed to the handler by a listener. It keeps
+//the values of the listener context, but is only canceled when a shutdown runs
+//out of time, so messages already received are still handled after the
+//listener stops.
+func (s *Server) handlerContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	result, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(s.aborted, cancel)
+	return result, func() {
+		stop()
+		cancel()
 	}
-	return false
 }
 
-func (s *Server) setRunning(val bool) {
-	if val {
-		atomic.StoreInt32(&s.running, 1)
-	} else {
-		atomic.StoreInt32(&s.running, 0)
+//watcher closes a socket when the server is stopped or the listener context
+//is done. Closing the socket is the only way to interrupt a blocked read or
+//accept on every kind of socket.
+type watcher struct {
+	mutex    sync.Mutex
+	err      error
+	released chan struct{}
+}
+
+func (s *Server) watch(ctx context.Context, socket io.Closer) *watcher {
+	w := new(watcher)
+	w.released = make(chan struct{})
+	go func() {
+		var err error
+		select {
+		case <-s.done:
+			err = ErrServerClosed
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-w.released:
+			return
+		}
+
+		w.mutex.Lock()
+		w.err = err
+		w.mutex.Unlock()
+		socket.Close()
+	}()
+	return w
+}
+
+//reason returns why the socket was closed, or nil if the watcher didn't close
+//it
+func (w *watcher) reason() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.err
+}
+
+//release stops watching the socket
+func (w *watcher) release() {
+	close(w.released)
+}
+
+//ignoreServerClosed hides ErrServerClosed from the Listen methods, which
+//return nil when the server is stopped
+func ignoreServerClosed(err error) error {
+	if err == ErrServerClosed {
+		return nil
 	}
+	return err
 }
@@ -1,7 +1,20 @@
 package mbsyslog_test
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"reflect"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -18,8 +31,13 @@ func TestServer(t *testing.T) {
 	}
 	client := mbsyslog.NewClient(false)
 
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+
 	messages := make(chan mbsyslog.Message, 5)
-	s := mbsyslog.NewServer(messages)
+	s := mbsyslog.NewServer(messages, mbsyslog.WithListenerConn(conn))
 	startTime := time.Now()
 	maxWait := float64(10)
 
@@ -40,7 +58,7 @@ func TestServer(t *testing.T) {
 
 	//send the messages from the client
 	for _, cm := range clientMsgs {
-		client.SendData("127.0.0.1", cm.data)
+		client.SendData(conn.LocalAddr().String(), cm.data)
 	}
 
 	//wait for the messages to be recieved from the server, verify they match
@@ -106,3 +124,893 @@ func TestServer(t *testing.T) {
 		time.Sleep(1 * time.Second)
 	}
 }
+
+//testCertificates creates a certificate authority, and a server and client
+//certificate signed by it, for testing TLS
+func testCertificates(t *testing.T) (*x509.CertPool, tls.Certificate, tls.Certificate) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mbsyslog test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %s", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("Failed to parse CA certificate: %s", err)
+	}
+
+	leaf := func(serial int64, usage x509.ExtKeyUsage) tls.Certificate {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatalf("Failed to generate key: %s", err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "localhost"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+			DNSNames:     []string{"localhost"},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatalf("Failed to create certificate: %s", err)
+		}
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	return pool, leaf(2, x509.ExtKeyUsageServerAuth), leaf(3, x509.ExtKeyUsageClientAuth)
+}
+
+//shutdown stops the server, failing the test if the messages already received
+//aren't handled in time
+func shutdown(t *testing.T, s *mbsyslog.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("Server.Shutdown() error: %s", err)
+	}
+}
+
+func TestServer_TLS(t *testing.T) {
+	pool, serverCert, clientCert := testCertificates(t)
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+
+	messages := make(chan mbsyslog.Message, 5)
+	s := mbsyslog.NewServer(messages, mbsyslog.WithListener(listener))
+	go func() {
+		if err := s.ListenTLS(serverConfig); err != nil {
+			t.Errorf("Server failed to start listening: %s", err.Error())
+		}
+	}()
+	defer shutdown(t, s)
+
+	startTime := time.Now()
+	for !s.Running() {
+		if time.Now().Sub(startTime).Seconds() >= 10 {
+			t.Fatal("Server failed to start running")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	addr := listener.Addr().String()
+	data := "<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - BOM'su root' failed for lonvick on /dev/pts/8"
+
+	//a client without a certificate is refused
+	untrusted := mbsyslog.NewClient(true, mbsyslog.WithTransport(mbsyslog.TransportTLS), mbsyslog.WithTLSConfig(&tls.Config{RootCAs: pool}))
+	defer untrusted.Close()
+	untrusted.SendData(addr, []byte(data))
+	select {
+	case m := <-messages:
+		t.Errorf("Server.ListenTLS() accepted message from client without certificate: %s", m)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	client := mbsyslog.NewClient(true, mbsyslog.WithTransport(mbsyslog.TransportTLS), mbsyslog.WithTLSConfig(&tls.Config{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{clientCert},
+	}))
+	defer client.Close()
+
+	for x := 0; x < 2; x++ {
+		if err := client.SendData(addr, []byte(data)); err != nil {
+			t.Fatalf("Client.SendData() error: %s", err)
+		}
+	}
+
+	for x := 0; x < 2; x++ {
+		select {
+		case m := <-messages:
+			if m.Format() != mbsyslog.MessageFormatRFC5424 || m.Hostname() != "mymachine.example.com" {
+				t.Errorf("Server.ListenTLS() received %s", m)
+			}
+			if source := m.Source(); !source.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+				t.Errorf("Message.Source() = %v, want 127.0.0.1", source)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Server.ListenTLS() message never received")
+		}
+	}
+}
+
+func TestServer_TCP(t *testing.T) {
+	streams := []struct {
+		name string
+		data string
+	}{
+		{"OctetCounting", "23 <13>1 - - - - - - first24 <13>1 - - - - - - second"},
+		{"NonTransparent", "<13>1 - - - - - - first\n<13>1 - - - - - - second\n"},
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+
+	messages := make(chan mbsyslog.Message, 5)
+	s := mbsyslog.NewServer(messages, mbsyslog.WithListener(listener))
+	go func() {
+		if err := s.ListenTCP(); err != nil {
+			t.Errorf("Server failed to start listening: %s", err.Error())
+		}
+	}()
+	defer shutdown(t, s)
+
+	startTime := time.Now()
+	for !s.Running() {
+		if time.Now().Sub(startTime).Seconds() >= 10 {
+			t.Fatal("Server failed to start running")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	//open every connection before writing, so they are read concurrently
+	var conns []net.Conn
+	for range streams {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to connect: %s", err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	for x, stream := range streams {
+		if _, err := conns[x].Write([]byte(stream.data)); err != nil {
+			t.Fatalf("%s: failed to write: %s", stream.name, err)
+		}
+	}
+
+	received := make(map[string]int)
+	for x := 0; x < 2*len(streams); x++ {
+		select {
+		case m := <-messages:
+			if m.Format() != mbsyslog.MessageFormatRFC5424 {
+				t.Errorf("Server.ListenTCP() received %s, format %s", m, m.Format())
+			}
+			received[m.Content()]++
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Server.ListenTCP() messages never received, got %v", received)
+		}
+	}
+
+	for _, content := range []string{"first", "second"} {
+		if received[content] != len(streams) {
+			t.Errorf("Server.ListenTCP() received %q %d times, want %d", content, received[content], len(streams))
+		}
+	}
+}
+
+//failingListener fails the first accepts, as a listener does when the process
+//runs out of file descriptors
+type failingListener struct {
+	net.Listener
+	failures atomic.Int32
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	if l.failures.Add(-1) >= 0 {
+		return nil, errors.New("too many open files")
+	}
+	return l.Listener.Accept()
+}
+
+func TestServer_AcceptError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	failing := &failingListener{Listener: listener}
+	failing.failures.Store(3)
+
+	messages := make(chan mbsyslog.Message, 5)
+	s := mbsyslog.NewServer(messages, mbsyslog.WithListener(failing))
+	served := make(chan error, 1)
+	go func() {
+		served <- s.ListenTCP()
+	}()
+
+	//the server keeps accepting after the errors
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %s", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("<13>1 - - - - - - accepted\n")); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+	select {
+	case m := <-messages:
+		if m.Content() != "accepted" {
+			t.Errorf("Server received %q", m.Content())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server stopped accepting after an error")
+	}
+
+	shutdown(t, s)
+	if err := <-served; err != nil {
+		t.Errorf("Server.ListenTCP() error: %s", err)
+	}
+}
+
+//failingPacketConn fails every read while failing is set, counting the reads
+type failingPacketConn struct {
+	net.PacketConn
+	failing atomic.Bool
+	reads   atomic.Int32
+}
+
+func (c *failingPacketConn) ReadFrom(buffer []byte) (int, net.Addr, error) {
+	c.reads.Add(1)
+	if c.failing.Load() {
+		return 0, nil, errors.New("network is down")
+	}
+	return c.PacketConn.ReadFrom(buffer)
+}
+
+func TestServer_ReadError(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	failing := &failingPacketConn{PacketConn: conn}
+	failing.failing.Store(true)
+
+	messages := make(chan mbsyslog.Message, 5)
+	s := mbsyslog.NewServer(messages, mbsyslog.WithListenerConn(failing))
+	go s.Listen()
+	defer shutdown(t, s)
+
+	//the server waits between reads that fail instead of spinning
+	time.Sleep(100 * time.Millisecond)
+	if reads := failing.reads.Load(); reads > 10 {
+		t.Errorf("Server read %d times in 100ms while reads failed", reads)
+	}
+	failing.failing.Store(false)
+
+	client := mbsyslog.NewClient(true)
+	if err := client.SendData(conn.LocalAddr().String(), []byte("<13>1 - - - - - - recovered")); err != nil {
+		t.Fatalf("Client.SendData() error: %s", err)
+	}
+	select {
+	case m := <-messages:
+		if m.Content() != "recovered" {
+			t.Errorf("Server received %q", m.Content())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server stopped reading after an error")
+	}
+}
+
+func TestNewServer_Options(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     []mbsyslog.ServerOption
+		wantAddress string
+		wantPort    int
+		wantSize    int
+	}{
+		{"Defaults", nil, "", 514, 8192},
+		{"Address", []mbsyslog.ServerOption{mbsyslog.WithAddress("127.0.0.1")}, "127.0.0.1", 514, 8192},
+		{"AddressIPv6", []mbsyslog.ServerOption{mbsyslog.WithAddress("::1")}, "::1", 514, 8192},
+		{"AddressIPv6Brackets", []mbsyslog.ServerOption{mbsyslog.WithAddress("[::1]")}, "::1", 514, 8192},
+		{"Port", []mbsyslog.ServerOption{mbsyslog.WithPort(10514)}, "", 10514, 8192},
+		{"MaxMessageSize", []mbsyslog.ServerOption{mbsyslog.WithMaxMessageSize(65535)}, "", 514, 65535},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := mbsyslog.NewServer(nil, tt.options...)
+			if got := s.Address(); got != tt.wantAddress {
+				t.Errorf("Server.Address() = %v, want %v", got, tt.wantAddress)
+			}
+			if got := s.Port(); got != tt.wantPort {
+				t.Errorf("Server.Port() = %v, want %v", got, tt.wantPort)
+			}
+			if got := s.MaximumMessageSize(); got != tt.wantSize {
+				t.Errorf("Server.MaximumMessageSize() = %v, want %v", got, tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestServer_ListenAddress(t *testing.T) {
+	//find a free port, then let the server open its own socket on it
+	probe, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	port := probe.LocalAddr().(*net.UDPAddr).Port
+	probe.Close()
+
+	messages := make(chan mbsyslog.Message, 5)
+	s := mbsyslog.NewServer(messages, mbsyslog.WithAddress("127.0.0.1"), mbsyslog.WithPort(port), mbsyslog.WithMaxMessageSize(65000))
+	go func() {
+		if err := s.Listen(); err != nil {
+			t.Errorf("Server failed to start listening: %s", err.Error())
+		}
+	}()
+	defer shutdown(t, s)
+
+	startTime := time.Now()
+	for !s.Running() {
+		if time.Now().Sub(startTime).Seconds() >= 10 {
+			t.Fatal("Server failed to start running")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	//a datagram larger than the default maximum size arrives intact
+	data := "<13>" + strings.Repeat("x", 60000)
+	client := mbsyslog.NewClient(true)
+	if err := client.SendData(net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), []byte(data)); err != nil {
+		t.Fatalf("Client.SendData() error: %s", err)
+	}
+
+	select {
+	case m := <-messages:
+		if got := m.Content(); len(got) != 60000 {
+			t.Errorf("Message.Content() length = %d, want %d", len(got), 60000)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server.Listen() message never received")
+	}
+}
+
+func TestServer_DualStack(t *testing.T) {
+	//find a free port on both stacks, then let the server open its own socket
+	probe, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	port := strconv.Itoa(probe.LocalAddr().(*net.UDPAddr).Port)
+	probe.Close()
+
+	messages := make(chan mbsyslog.Message, 5)
+	s := mbsyslog.NewServer(messages, mbsyslog.WithPort(probe.LocalAddr().(*net.UDPAddr).Port))
+	go func() {
+		if err := s.Listen(); err != nil {
+			t.Errorf("Server failed to start listening: %s", err.Error())
+		}
+	}()
+	defer shutdown(t, s)
+
+	startTime := time.Now()
+	for !s.Running() {
+		if time.Now().Sub(startTime).Seconds() >= 10 {
+			t.Fatal("Server failed to start running")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	tests := []struct {
+		name       string
+		addr       string
+		wantIP     net.IP
+		wantString string
+	}{
+		{"IPv4", net.JoinHostPort("127.0.0.1", port), net.IPv4(127, 0, 0, 1), "127.0.0.1 <13>The quick brown fox jumps over the lazy dog"},
+		{"IPv6", "[::1]:" + port, net.IPv6loopback, "::1 <13>The quick brown fox jumps over the lazy dog"},
+	}
+	client := mbsyslog.NewClient(true)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := client.SendData(tt.addr, []byte("<13>The quick brown fox jumps over the lazy dog")); err != nil {
+				t.Fatalf("Client.SendData() error: %s", err)
+			}
+
+			select {
+			case m := <-messages:
+				if source := m.Source(); !source.IP.Equal(tt.wantIP) {
+					t.Errorf("Message.Source() = %v, want %v", source.IP, tt.wantIP)
+				}
+				if got := m.String(); got != tt.wantString {
+					t.Errorf("Message.String() = %v, want %v", got, tt.wantString)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Server.Listen() message never received")
+			}
+		})
+	}
+}
+
+func TestServer_ParseOptions(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+
+	location := time.FixedZone("UTC-5", -5*60*60)
+	messages := make(chan mbsyslog.Message, 5)
+	s := mbsyslog.NewServer(messages, mbsyslog.WithListenerConn(conn),
+		mbsyslog.WithParseOptions(mbsyslog.ParseOptions{Mode: mbsyslog.ParseModeRFC3164, Location: location}))
+	go func() {
+		if err := s.Listen(); err != nil {
+			t.Errorf("Server failed to start listening: %s", err.Error())
+		}
+	}()
+	defer shutdown(t, s)
+
+	//the RFC 5424 message fails strict RFC 3164 parsing and is dropped
+	client := mbsyslog.NewClient(true)
+	if err := client.SendData(conn.LocalAddr().String(), []byte("<13>1 2003-10-11T22:14:15.003Z host app - - - dropped")); err != nil {
+		t.Fatalf("Client.SendData() error: %s", err)
+	}
+	if err := client.SendData(conn.LocalAddr().String(), []byte("<13>Jan  2 15:04:05 host app: kept")); err != nil {
+		t.Fatalf("Client.SendData() error: %s", err)
+	}
+
+	select {
+	case m := <-messages:
+		if m.Content() != "kept" {
+			t.Errorf("Message.Content() = %v, want kept", m.Content())
+		}
+		if m.Date().Location() != location || m.Date().Day() != 2 || m.Date().Hour() != 15 {
+			t.Errorf("Message.Date() = %v, want Jan 2 15:04:05 in %v", m.Date(), location)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server.Listen() message never received")
+	}
+}
+
+//listenerKey is the context key the test middleware adds
+type listenerKey struct{}
+
+func TestNewHandlerServer(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+
+	//the handler is called inline, enriched by middleware
+	received := make(chan string, 5)
+	handler := mbsyslog.Chain(mbsyslog.HandlerFunc(func(ctx context.Context, m *mbsyslog.Message) error {
+		received <- ctx.Value(listenerKey{}).(string) + " " + m.Content()
+		return nil
+	}), func(next mbsyslog.Handler) mbsyslog.Handler {
+		return mbsyslog.HandlerFunc(func(ctx context.Context, m *mbsyslog.Message) error {
+			return next.HandleMessage(context.WithValue(ctx, listenerKey{}, "udp"), m)
+		})
+	}, mbsyslog.Filter(func(m *mbsyslog.Message) bool {
+		return m.Content() != "skipped"
+	}))
+
+	s := mbsyslog.NewHandlerServer(handler, mbsyslog.WithListenerConn(conn))
+	go func() {
+		if err := s.Listen(); err != nil {
+			t.Errorf("Server failed to start listening: %s", err.Error())
+		}
+	}()
+	defer shutdown(t, s)
+
+	client := mbsyslog.NewClient(true)
+	for _, content := range []string{"skipped", "kept"} {
+		if err := client.SendData(conn.LocalAddr().String(), []byte("<13>"+content)); err != nil {
+			t.Fatalf("Client.SendData() error: %s", err)
+		}
+	}
+
+	select {
+	case got := <-received:
+		if got != "udp kept" {
+			t.Errorf("Handler.HandleMessage() got %v, want udp kept", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server.Listen() message never received")
+	}
+}
+
+func TestServer_Overflow(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   mbsyslog.OverflowPolicy
+		sent     []string
+		want     []string
+		wantDrop uint64
+	}{
+		{"Block", mbsyslog.OverflowBlock, []string{"<14>2", "<14>3", "<14>4"}, []string{"1", "2", "3", "4"}, 0},
+		{"DropNewest", mbsyslog.OverflowDropNewest, []string{"<14>2", "<14>3", "<14>4"}, []string{"1", "2"}, 2},
+		{"DropOldest", mbsyslog.OverflowDropOldest, []string{"<14>2", "<14>3", "<14>4"}, []string{"1", "4"}, 2},
+		{"DropBySeverity", mbsyslog.OverflowDropBySeverity, []string{"<14>2", "<15>3", "<12>4", "invalid", "<11>5"}, []string{"1", "2", "5"}, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Failed to listen: %s", err)
+			}
+
+			//the first message holds the only worker until released
+			started := make(chan struct{})
+			release := make(chan struct{})
+			received := make(chan string, 10)
+			handler := mbsyslog.HandlerFunc(func(ctx context.Context, m *mbsyslog.Message) error {
+				if m.Content() == "1" {
+					close(started)
+					<-release
+				}
+				received <- m.Content()
+				return nil
+			})
+
+			s := mbsyslog.NewHandlerServer(handler, mbsyslog.WithListenerConn(conn), mbsyslog.WithWorkers(1),
+				mbsyslog.WithQueueSize(1), mbsyslog.WithOverflowPolicy(tt.policy))
+			go func() {
+				if err := s.Listen(); err != nil {
+					t.Errorf("Server failed to start listening: %s", err.Error())
+				}
+			}()
+			defer shutdown(t, s)
+
+			client := mbsyslog.NewClient(true)
+			if err := client.SendData(conn.LocalAddr().String(), []byte("<14>1")); err != nil {
+				t.Fatalf("Client.SendData() error: %s", err)
+			}
+			select {
+			case <-started:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Server.Listen() message never received")
+			}
+			for _, data := range tt.sent {
+				if err := client.SendData(conn.LocalAddr().String(), []byte(data)); err != nil {
+					t.Fatalf("Client.SendData() error: %s", err)
+				}
+			}
+
+			//wait for the read loop to apply the policy to every datagram
+			deadline := time.Now().Add(5 * time.Second)
+			for s.Dropped(tt.policy) < tt.wantDrop && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			time.Sleep(100 * time.Millisecond)
+			close(release)
+
+			var got []string
+			for len(got) < len(tt.want) {
+				select {
+				case content := <-received:
+					got = append(got, content)
+				case <-time.After(5 * time.Second):
+					t.Fatalf("Server.Listen() received %v, want %v", got, tt.want)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Server.Listen() received %v, want %v", got, tt.want)
+			}
+			if dropped := s.Dropped(tt.policy); dropped != tt.wantDrop {
+				t.Errorf("Server.Dropped() = %v, want %v", dropped, tt.wantDrop)
+			}
+			if dropped := s.Stats().Total.Dropped; dropped != tt.wantDrop {
+				t.Errorf("Server.Stats() dropped = %v, want %v", dropped, tt.wantDrop)
+			}
+		})
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+
+	//the handler is slow, so messages are still queued when shutting down
+	var handled int32
+	handler := mbsyslog.HandlerFunc(func(ctx context.Context, m *mbsyslog.Message) error {
+		time.Sleep(50 * time.Millisecond)
+		atomic.AddInt32(&handled, 1)
+		return nil
+	})
+	s := mbsyslog.NewHandlerServer(handler, mbsyslog.WithListenerConn(conn), mbsyslog.WithWorkers(1))
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(context.Background())
+	}()
+	startTime := time.Now()
+	for !s.Running() {
+		if time.Now().Sub(startTime).Seconds() >= 10 {
+			t.Fatal("Server failed to start running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	client := mbsyslog.NewClient(true)
+	for index := 0; index < 5; index++ {
+		if err := client.SendData(conn.LocalAddr().String(), []byte("<13>text")); err != nil {
+			t.Fatalf("Client.SendData() error: %s", err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	//stopping more than once doesn't block
+	s.Stop()
+	s.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("Server.Shutdown() error: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("Server.Shutdown() took %v", elapsed)
+	}
+	if got := atomic.LoadInt32(&handled); got != 5 {
+		t.Errorf("Server.Shutdown() returned with %d messages handled, want 5", got)
+	}
+	if s.Running() {
+		t.Error("Server.Running() = true after Shutdown")
+	}
+	if err := <-served; err != mbsyslog.ErrServerClosed {
+		t.Errorf("Server.Serve() error = %v, want %v", err, mbsyslog.ErrServerClosed)
+	}
+
+	//shutting down again, or serving after shutting down, returns straight away
+	if err := s.Shutdown(ctx); err != nil {
+		t.Errorf("Server.Shutdown() error: %s", err)
+	}
+	if err := s.Serve(ctx); err != mbsyslog.ErrServerClosed {
+		t.Errorf("Server.Serve() error = %v, want %v", err, mbsyslog.ErrServerClosed)
+	}
+	if err := s.Listen(); err != nil {
+		t.Errorf("Server.Listen() error = %v", err)
+	}
+}
+
+func TestServer_ShutdownDeadline(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+
+	//nothing reads the channel, so the handler waits until its context is canceled
+	messages := make(chan mbsyslog.Message)
+	s := mbsyslog.NewServer(messages, mbsyslog.WithListenerConn(conn))
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(context.Background())
+	}()
+	startTime := time.Now()
+	for !s.Running() {
+		if time.Now().Sub(startTime).Seconds() >= 10 {
+			t.Fatal("Server failed to start running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	client := mbsyslog.NewClient(true)
+	if err := client.SendData(conn.LocalAddr().String(), []byte("<13>text")); err != nil {
+		t.Fatalf("Client.SendData() error: %s", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Server.Shutdown() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	select {
+	case err := <-served:
+		if err != mbsyslog.ErrServerClosed {
+			t.Errorf("Server.Serve() error = %v, want %v", err, mbsyslog.ErrServerClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server.Serve() didn't return after the handlers were canceled")
+	}
+}
+
+func TestServer_ServeContext(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+
+	messages := make(chan mbsyslog.Message, 5)
+	s := mbsyslog.NewServer(messages, mbsyslog.WithListener(listener))
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- s.ServeTCP(ctx)
+	}()
+	startTime := time.Now()
+	for !s.Running() {
+		if time.Now().Sub(startTime).Seconds() >= 10 {
+			t.Fatal("Server failed to start running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %s", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("<13>text\n"))
+	select {
+	case <-messages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server.ServeTCP() message never received")
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != context.Canceled {
+			t.Errorf("Server.ServeTCP() error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server.ServeTCP() didn't return when the context was canceled")
+	}
+	if s.Running() {
+		t.Error("Server.Running() = true after the context was canceled")
+	}
+
+	//the open connection was closed
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("Server.ServeTCP() left the connection open")
+	}
+}
+
+func TestServer_RELP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	handler := mbsyslog.HandlerFunc(func(ctx context.Context, m *mbsyslog.Message) error {
+		if m.Content() == "rejected" {
+			return errors.New("storage unavailable")
+		}
+		return nil
+	})
+	received := make(chan string, 5)
+	s := mbsyslog.NewHandlerServer(mbsyslog.Chain(handler, func(next mbsyslog.Handler) mbsyslog.Handler {
+		return mbsyslog.HandlerFunc(func(ctx context.Context, m *mbsyslog.Message) error {
+			received <- m.Content()
+			return next.HandleMessage(ctx, m)
+		})
+	}), mbsyslog.WithListener(listener))
+	go s.ServeRELP(context.Background())
+	defer shutdown(t, s)
+
+	client := mbsyslog.NewClient(true,
+		mbsyslog.WithTransport(mbsyslog.TransportRELP),
+		mbsyslog.WithDestination(listener.Addr().String()))
+	defer client.Close()
+
+	tests := []struct {
+		name    string
+		content string
+		handled bool
+		wantErr string
+	}{
+		{"Acknowledged", "accepted", true, ""},
+		{"Second", "accepted again", true, ""},
+		{"HandlerError", "rejected", true, "storage unavailable"},
+		{"TooLarge", strings.Repeat("x", 9000), false, "too large"},
+		{"AfterTooLarge", "accepted after", true, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := client.Info(tt.content)
+			if (err != nil) != (tt.wantErr != "") {
+				t.Fatalf("Client.Info() error = %v, want %q", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Client.Info() error = %v, want %q", err, tt.wantErr)
+			}
+
+			//the message was handled before the send returned
+			select {
+			case content := <-received:
+				if !tt.handled || content != tt.content {
+					t.Errorf("Server received %q, want %q", content, tt.content)
+				}
+			default:
+				if tt.handled {
+					t.Fatal("Client.Info() returned before the message was handled")
+				}
+			}
+		})
+	}
+
+	if stats := s.Stats().Total; stats.Datagrams != 4 || stats.HandlerErrors != 1 {
+		t.Errorf("Server.Stats() = %+v", stats)
+	}
+}
+
+func TestServer_RELPPort(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	relpAddr := unreachableAddress(t)
+	_, relpPort, _ := net.SplitHostPort(relpAddr)
+	port, _ := strconv.Atoi(relpPort)
+
+	//RELP runs next to plain TCP syslog on its own port
+	messages := make(chan mbsyslog.Message, 5)
+	s := mbsyslog.NewServer(messages, mbsyslog.WithAddress("127.0.0.1"), mbsyslog.WithListener(listener), mbsyslog.WithRELPPort(port))
+	go s.ServeTCP(context.Background())
+	go s.ServeRELP(context.Background())
+	defer shutdown(t, s)
+
+	tests := []struct {
+		name      string
+		transport mbsyslog.Transport
+		addr      string
+	}{
+		{"TCP", mbsyslog.TransportTCP, listener.Addr().String()},
+		{"RELP", mbsyslog.TransportRELP, relpAddr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := mbsyslog.NewClient(true, mbsyslog.WithTransport(tt.transport), mbsyslog.WithDestination(tt.addr))
+			defer client.Close()
+
+			//the listener may still be opening
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				err := client.Info(tt.name)
+				if err == nil {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("Client.Info() error: %s", err)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			select {
+			case m := <-messages:
+				if m.Content() != tt.name {
+					t.Errorf("Server received %q, want %q", m.Content(), tt.name)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Server never received the message")
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package mbsyslog
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+//Levels between and above the slog levels, matching the syslog severities
+//that slog doesn't define
+const (
+	//SlogLevelNotice logs with MessageSeverityNotice
+	SlogLevelNotice = slog.LevelInfo + 2
+	//SlogLevelCritical logs with MessageSeverityCritical
+	SlogLevelCritical = slog.LevelError + 4
+	//SlogLevelAlert logs with MessageSeverityAlert
+	SlogLevelAlert = slog.LevelError + 8
+	//SlogLevelEmergency logs with MessageSeverityEmergency
+	SlogLevelEmergency = slog.LevelError + 12
+)
+
+//defaultEnterpriseID is the private enterprise number RFC 5612 reserves for
+//documentation, used when no enterprise number is configured
+const defaultEnterpriseID = "32473"
+
+//SlogHandlerOptions configures a SlogHandler
+type SlogHandlerOptions struct {
+	//Level is the minimum level that is sent, the default is slog.LevelInfo
+	Level slog.Leveler
+	//EnterpriseID is the private enterprise number added to element ids, the
+	//default is 32473
+	EnterpriseID string
+	//ElementName is the name of the element holding attributes that aren't in
+	//a group, the default is "slog"
+	ElementName string
+}
+
+//SlogHandler is a log/slog handler that sends records through a client.
+//Attributes become RFC 5424 structured data parameters. Attributes outside of
+//a group are added to one element, and each top level group becomes its own
+//element named after the group. Attributes in nested groups are named with
+//the group path, such as "request.method".
+type SlogHandler struct {
+	client       *Client
+	level        slog.Leveler
+	enterpriseID string
+	elementName  string
+	groups       []string
+	params       []slogParam
+}
+
+//slogParam is an attribute that has been converted to a parameter of an element
+type slogParam struct {
+	element string
+	name    string
+	value   string
+}
+
+//NewSlogHandler creates a handler sending through the client, which should
+//have a destination set. The options may be nil to use the defaults.
+func NewSlogHandler(client *Client, options *SlogHandlerOptions) *SlogHandler {
+	result := new(SlogHandler)
+	result.client = client
+	result.level = slog.LevelInfo
+	result.enterpriseID = defaultEnterpriseID
+	result.elementName = "slog"
+
+	if options != nil {
+		if options.Level != nil {
+			result.level = options.Level
+		}
+		if options.EnterpriseID != "" {
+			result.enterpriseID = options.EnterpriseID
+		}
+		if options.ElementName != "" {
+			result.elementName = options.ElementName
+		}
+	}
+	return result
+}
+
+//Enabled reports whether records at the level are sent
+func (h *SlogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+//Handle sends the record with the severity matching its level. With a
+//synchronous client the context limits how long sending may take. An
+//asynchronous client returns straight away and sends the record even once the
+//context is done, so logging with a request context doesn't lose records
+//when the request ends.
+func (h *SlogHandler) Handle(ctx context.Context, r slog.Record) error {
+	date := r.Time
+	if date.IsZero() {
+		date = time.Now()
+	}
+	builder := h.client.newMessageBuilder(SlogSeverity(r.Level)).Timestamp(date).Content(r.Message)
+
+	params := h.params
+	if r.NumAttrs() > 0 {
+		params = append([]slogParam(nil), h.params...)
+		r.Attrs(func(a slog.Attr) bool {
+			params = h.appendAttr(params, h.groups, a)
+			return true
+		})
+	}
+
+	//group the parameters into elements, in the order each element first
+	//appears
+	var order []string
+	elements := make(map[string][]*Parameter)
+	for _, p := range params {
+		if _, found := elements[p.element]; !found {
+			order = append(order, p.element)
+		}
+		elements[p.element] = append(elements[p.element], NewParameter(p.name, p.value))
+	}
+	for _, id := range order {
+		builder.Element(id, elements[id]...)
+	}
+
+	m, err := builder.Build()
+	if err != nil {
+		return err
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if !h.client.syncSend {
+		ctx = context.WithoutCancel(ctx)
+	}
+	return h.client.SendContext(ctx, m)
+}
+
+//WithAttrs returns a handler that adds the attributes to every record
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+
+	result := h.clone()
+	for _, a := range attrs {
+		result.params = result.appendAttr(result.params, result.groups, a)
+	}
+	return result
+}
+
+//WithGroup returns a handler that adds later attributes to the group
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	result := h.clone()
+	result.groups = append(result.groups, name)
+	return result
+}
+
+//SlogSeverity returns the syslog severity used for the slog level
+func SlogSeverity(level slog.Level) MessageSeverity {
+	switch {
+	case level >= SlogLevelEmergency:
+		return MessageSeverityEmergency
+	case level >= SlogLevelAlert:
+		return MessageSeverityAlert
+	case level >= SlogLevelCritical:
+		return MessageSeverityCritical
+	case level >= slog.LevelError:
+		return MessageSeverityError
+	case level >= slog.LevelWarn:
+		return MessageSeverityWarning
+	case level > slog.LevelInfo:
+		return MessageSeverityNotice
+	case level >= slog.LevelInfo:
+		return MessageSeverityInformational
+	default:
+		return MessageSeverityDebug
+	}
+}
+
+func (h *SlogHandler) clone() *SlogHandler {
+	result := *h
+	result.groups = append([]string(nil), h.groups...)
+	result.params = append([]slogParam(nil), h.params...)
+	return &result
+}
+
+//appendAttr converts the attribute to parameters, following the slog rules
+//for empty attributes and groups
+func (h *SlogHandler) appendAttr(params []slogParam, groups []string, a slog.Attr) []slogParam {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return params
+	}
+
+	if a.Value.Kind() == slog.KindGroup {
+		attrs := a.Value.Group()
+		if len(attrs) == 0 {
+			return params
+		}
+		//a group with an empty key is inlined
+		if a.Key != "" {
+			groups = append(append([]string(nil), groups...), a.Key)
+		}
+		for _, ga := range attrs {
+			params = h.appendAttr(params, groups, ga)
+		}
+		return params
+	}
+
+	//the first group names the element, the rest name the parameter
+	element := h.elementID(h.elementName)
+	name := a.Key
+	if len(groups) > 0 {
+		element = h.elementID(groups[0])
+		name = strings.Join(append(append([]string(nil), groups[1:]...), a.Key), ".")
+	}
+
+	var value string
+	if a.Value.Kind() == slog.KindTime {
+		value = a.Value.Time().Format(time.RFC3339Nano)
+	} else {
+		value = a.Value.String()
+	}
+	return append(params, slogParam{element: element, name: sdName(name), value: value})
+}
+
+//elementID adds the enterprise number to the name to make an element id.
+//Names that already have an enterprise number, or are one of the ids
+//registered in RFC 5424, are used as is.
+func (h *SlogHandler) elementID(name string) string {
+	switch name {
+	case "timeQuality", "origin", "meta":
+		return name
+	}
+	if strings.Contains(name, "@") && isSDName(name) {
+		return name
+	}
+
+	suffix := "@" + h.enterpriseID
+	result := strings.Replace(sdName(name), "@", "_", -1)
+	if len(result) > 32-len(suffix) {
+		result = result[:32-len(suffix)]
+	}
+	return result + suffix
+}
+
+//sdName replaces the characters not allowed in an element id or parameter
+//name with underscores, and truncates it to the 32 characters allowed
+func sdName(name string) string {
+	result := []byte(name)
+	for index, c := range result {
+		if c < 33 || c > 126 || c == '=' || c == ']' || c == '"' || c == ' ' {
+			result[index] = '_'
+		}
+	}
+	if len(result) == 0 {
+		return "_"
+	}
+	if len(result) > 32 {
+		result = result[:32]
+	}
+	return string(result)
+}
@@ -0,0 +1,129 @@
+package mbsyslog_test
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/venutios/mbsyslog"
+)
+
+func TestSlogSeverity(t *testing.T) {
+	tests := []struct {
+		name  string
+		level slog.Level
+		want  mbsyslog.MessageSeverity
+	}{
+		{"Debug", slog.LevelDebug, mbsyslog.MessageSeverityDebug},
+		{"BelowInfo", slog.LevelInfo - 1, mbsyslog.MessageSeverityDebug},
+		{"Info", slog.LevelInfo, mbsyslog.MessageSeverityInformational},
+		{"Notice", mbsyslog.SlogLevelNotice, mbsyslog.MessageSeverityNotice},
+		{"Warn", slog.LevelWarn, mbsyslog.MessageSeverityWarning},
+		{"Error", slog.LevelError, mbsyslog.MessageSeverityError},
+		{"Critical", mbsyslog.SlogLevelCritical, mbsyslog.MessageSeverityCritical},
+		{"Alert", mbsyslog.SlogLevelAlert, mbsyslog.MessageSeverityAlert},
+		{"Emergency", mbsyslog.SlogLevelEmergency, mbsyslog.MessageSeverityEmergency},
+		{"AboveEmergency", mbsyslog.SlogLevelEmergency + 10, mbsyslog.MessageSeverityEmergency},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mbsyslog.SlogSeverity(tt.level); got != tt.want {
+				t.Errorf("SlogSeverity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlogHandler(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer conn.Close()
+
+	client := mbsyslog.NewClient(true,
+		mbsyslog.WithDestination(conn.LocalAddr().String()),
+		mbsyslog.WithFacility(mbsyslog.MessageFacilityLocal0),
+		mbsyslog.WithHostname("host"),
+		mbsyslog.WithApplication("app"),
+		mbsyslog.WithProcessID(42))
+	logger := slog.New(mbsyslog.NewSlogHandler(client, &mbsyslog.SlogHandlerOptions{Level: slog.LevelDebug}))
+
+	tests := []struct {
+		name string
+		log  func()
+		want string
+	}{
+		{"NoAttributes", func() { logger.Info("started") }, "<134>1 host app 42 - - \xEF\xBB\xBFstarted"},
+		{"Attributes", func() { logger.Warn("slow", "ms", 1500, "path", "/a \"b\"]") }, "<132>1 host app 42 - [slog@32473 ms=\"1500\" path=\"/a \\\"b\\\"\\]\"] \xEF\xBB\xBFslow"},
+		{"Group", func() {
+			logger.Error("failed", "code", 7, slog.Group("request", "method", "GET", slog.Group("header", "host", "example.com")))
+		}, "<131>1 host app 42 - [slog@32473 code=\"7\"][request@32473 method=\"GET\" header.host=\"example.com\"] \xEF\xBB\xBFfailed"},
+		{"WithGroup", func() {
+			logger.WithGroup("db").With("table", "users").Debug("query", "rows", 3)
+		}, "<135>1 host app 42 - [db@32473 table=\"users\" rows=\"3\"] \xEF\xBB\xBFquery"},
+		{"WithAttrs", func() {
+			logger.With("origin", "x").With(slog.Group("origin", "ip", "192.0.2.1")).Log(context.Background(), mbsyslog.SlogLevelCritical, "down")
+		}, "<130>1 host app 42 - [slog@32473 origin=\"x\"][origin ip=\"192.0.2.1\"] \xEF\xBB\xBFdown"},
+		{"EmptyAttributes", func() { logger.Info("empty", slog.Attr{}, slog.Group("none"), slog.Group("", "inline", true)) }, "<134>1 host app 42 - [slog@32473 inline=\"true\"] \xEF\xBB\xBFempty"},
+		{"InvalidNames", func() { logger.Info("names", "a key=\"x\"]", 1, slog.Group("my group@x", "v", 2)) }, "<134>1 host app 42 - [slog@32473 a_key__x__=\"1\"][my_group_x@32473 v=\"2\"] \xEF\xBB\xBFnames"},
+	}
+	buffer := make([]byte, 8192)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.log()
+
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			count, _, err := conn.ReadFrom(buffer)
+			if err != nil {
+				t.Fatalf("SlogHandler message never received: %s", err)
+			}
+
+			//remove the timestamp, which changes with every message
+			fields := strings.SplitN(string(buffer[:count]), " ", 3)
+			if len(fields) != 3 {
+				t.Fatalf("SlogHandler sent %q", buffer[:count])
+			}
+			if got := fields[0] + " " + fields[2]; got != tt.want {
+				t.Errorf("SlogHandler sent %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	//records below the level aren't sent
+	if logger.Handler().Enabled(context.Background(), slog.LevelDebug-1) {
+		t.Error("SlogHandler.Enabled() = true below the level")
+	}
+}
+
+func TestSlogHandler_Context(t *testing.T) {
+	addr, frames := frameListener(t, "127.0.0.1:0")
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	record := slog.NewRecord(time.Now(), slog.LevelInfo, "canceled", 0)
+
+	//a synchronous send gives up when the context is done
+	client := mbsyslog.NewClient(true, mbsyslog.WithTransport(mbsyslog.TransportTCP), mbsyslog.WithDestination(addr))
+	defer client.Close()
+	if err := mbsyslog.NewSlogHandler(client, nil).Handle(canceled, record); err != context.Canceled {
+		t.Errorf("SlogHandler.Handle() error = %v, want %v", err, context.Canceled)
+	}
+
+	//an asynchronous send carries on after the context is done
+	client = mbsyslog.NewClient(false, mbsyslog.WithTransport(mbsyslog.TransportTCP), mbsyslog.WithDestination(addr))
+	defer client.Close()
+	if err := mbsyslog.NewSlogHandler(client, nil).Handle(canceled, record); err != nil {
+		t.Errorf("SlogHandler.Handle() error: %s", err)
+	}
+	select {
+	case frame := <-frames:
+		if !strings.HasSuffix(frame, "canceled") {
+			t.Errorf("SlogHandler sent %q", frame)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SlogHandler message never received")
+	}
+}
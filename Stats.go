@@ -0,0 +1,242 @@
+package mbsyslog
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//maxSourceStats limits how many source addresses have their own statistics,
+//so a flood of senders can't grow them without bound. Later sources are
+//counted together under otherSources.
+const maxSourceStats = 10000
+
+//otherSources is the source address used once maxSourceStats is reached
+const otherSources = "other"
+
+//localSource is the source address of messages from Unix sockets
+const localSource = "local"
+
+//ReceiveStats counts what a server received, for the whole server, a
+//listener or a source address
+type ReceiveStats struct {
+	//Datagrams is the number of datagrams, or frames on streams, received
+	Datagrams uint64
+	//Bytes is the size of the datagrams and frames received
+	Bytes uint64
+	//Truncated is the number of messages cut to the maximum message size
+	Truncated uint64
+	//Dropped is the number of datagrams dropped by the overflow policy
+	Dropped uint64
+	//ParseFailures is the number of messages that failed strict parsing, or
+	//had no known format when parsed tolerantly
+	ParseFailures uint64
+	//Formats is the number of messages parsed in each format
+	Formats map[MessageFormat]uint64
+	//HandlerErrors is the number of messages the handler returned an error for
+	HandlerErrors uint64
+	//HandlerCalls is the number of messages passed to the handler
+	HandlerCalls uint64
+	//HandlerTime is the total time spent in the handler
+	HandlerTime time.Duration
+}
+
+//ServerStats is a snapshot of the statistics of a server
+type ServerStats struct {
+	//Total counts everything the server received
+	Total ReceiveStats
+	//Listeners breaks the counts down by listener, named by the network and
+	//local address such as "udp [::]:514"
+	Listeners map[string]ReceiveStats
+	//Sources breaks the counts down by the IP address of the sender. Messages
+	//from Unix sockets are counted under "local".
+	Sources map[string]ReceiveStats
+}
+
+//MetricsHook is told about every event counted in the server statistics, to
+//feed them into another metrics system. The methods are called concurrently
+//from the listeners and workers, and should return quickly.
+type MetricsHook interface {
+	//Received is called for every datagram or frame received
+	Received(listener string, source net.Addr, bytes int, truncated bool)
+	//Dropped is called when the overflow policy drops a datagram
+	Dropped(listener string, source net.Addr, policy OverflowPolicy)
+	//Parsed is called after parsing, with the error when strict parsing failed
+	Parsed(listener string, source net.Addr, format MessageFormat, err error)
+	//Handled is called after the handler returns
+	Handled(listener string, source net.Addr, latency time.Duration, err error)
+}
+
+//WithMetricsHook sets a hook that is told about every event counted in the
+//server statistics
+func WithMetricsHook(hook MetricsHook) ServerOption {
+	return func(s *Server) {
+		s.metricsHook = hook
+	}
+}
+
+//Stats returns a snapshot of the statistics of the server
+func (s *Server) Stats() ServerStats {
+	return s.stats.snapshot()
+}
+
+//statsRecorder keeps the statistics of a server. The counters are updated
+//atomically, so the listeners and workers don't wait on each other to count.
+type statsRecorder struct {
+	total       receiveCounters
+	listeners   sync.Map
+	sources     sync.Map
+	sourceCount atomic.Int64
+}
+
+//receiveCounters are the counts behind ReceiveStats
+type receiveCounters struct {
+	datagrams     atomic.Uint64
+	bytes         atomic.Uint64
+	truncated     atomic.Uint64
+	dropped       atomic.Uint64
+	parseFailures atomic.Uint64
+	formats       [MessageFormatSimple + 1]atomic.Uint64
+	handlerErrors atomic.Uint64
+	handlerCalls  atomic.Uint64
+	handlerTime   atomic.Int64
+}
+
+func newStatsRecorder() *statsRecorder {
+	return new(statsRecorder)
+}
+
+//counters returns the counters of the whole server, the listener and the
+//source, adding those seen for the first time
+func (r *statsRecorder) counters(listener string, source net.Addr) [3]*receiveCounters {
+	listenerCounters, found := r.listeners.Load(listener)
+	if !found {
+		listenerCounters, _ = r.listeners.LoadOrStore(listener, new(receiveCounters))
+	}
+
+	key := sourceKey(source)
+	sourceCounters, found := r.sources.Load(key)
+	if !found {
+		if r.sourceCount.Add(1) > maxSourceStats {
+			r.sourceCount.Add(-1)
+			key = otherSources
+		}
+		var loaded bool
+		sourceCounters, loaded = r.sources.LoadOrStore(key, new(receiveCounters))
+		if loaded && key != otherSources {
+			r.sourceCount.Add(-1)
+		}
+	}
+	return [3]*receiveCounters{&r.total, listenerCounters.(*receiveCounters), sourceCounters.(*receiveCounters)}
+}
+
+func (r *statsRecorder) snapshot() ServerStats {
+	result := ServerStats{
+		Total:     r.total.stats(),
+		Listeners: make(map[string]ReceiveStats),
+		Sources:   make(map[string]ReceiveStats),
+	}
+	r.listeners.Range(func(name, counters any) bool {
+		result.Listeners[name.(string)] = counters.(*receiveCounters).stats()
+		return true
+	})
+	r.sources.Range(func(address, counters any) bool {
+		result.Sources[address.(string)] = counters.(*receiveCounters).stats()
+		return true
+	})
+	return result
+}
+
+//stats reads the counters, leaving out the formats never seen
+func (rc *receiveCounters) stats() ReceiveStats {
+	result := ReceiveStats{
+		Datagrams:     rc.datagrams.Load(),
+		Bytes:         rc.bytes.Load(),
+		Truncated:     rc.truncated.Load(),
+		Dropped:       rc.dropped.Load(),
+		ParseFailures: rc.parseFailures.Load(),
+		HandlerErrors: rc.handlerErrors.Load(),
+		HandlerCalls:  rc.handlerCalls.Load(),
+		HandlerTime:   time.Duration(rc.handlerTime.Load()),
+	}
+	for format := range rc.formats {
+		if count := rc.formats[format].Load(); count > 0 {
+			if result.Formats == nil {
+				result.Formats = make(map[MessageFormat]uint64)
+			}
+			result.Formats[MessageFormat(format)] = count
+		}
+	}
+	return result
+}
+
+//sourceKey is the name of the source in the statistics. Senders are counted
+//by IP address, as the port usually changes between connections.
+func sourceKey(source net.Addr) string {
+	switch addr := source.(type) {
+	case *net.UDPAddr:
+		if addr != nil {
+			return addr.IP.String()
+		}
+	case *net.TCPAddr:
+		if addr != nil {
+			return addr.IP.String()
+		}
+	case *net.UnixAddr, nil:
+		//senders on Unix sockets are usually unbound, so are counted together
+		return localSource
+	default:
+		return addr.String()
+	}
+	return ""
+}
+
+func (s *Server) recordReceived(listener string, source net.Addr, bytes int, truncated bool) {
+	for _, rc := range s.stats.counters(listener, source) {
+		rc.datagrams.Add(1)
+		rc.bytes.Add(uint64(bytes))
+		if truncated {
+			rc.truncated.Add(1)
+		}
+	}
+	if s.metricsHook != nil {
+		s.metricsHook.Received(listener, source, bytes, truncated)
+	}
+}
+
+func (s *Server) recordDropped(listener string, source net.Addr, policy OverflowPolicy) {
+	for _, rc := range s.stats.counters(listener, source) {
+		rc.dropped.Add(1)
+	}
+	if s.metricsHook != nil {
+		s.metricsHook.Dropped(listener, source, policy)
+	}
+}
+
+func (s *Server) recordParsed(listener string, source net.Addr, format MessageFormat, err error) {
+	for _, rc := range s.stats.counters(listener, source) {
+		if err != nil || format == MessageFormatUnknown {
+			rc.parseFailures.Add(1)
+		}
+		if err == nil && int(format) < len(rc.formats) {
+			rc.formats[format].Add(1)
+		}
+	}
+	if s.metricsHook != nil {
+		s.metricsHook.Parsed(listener, source, format, err)
+	}
+}
+
+func (s *Server) recordHandled(listener string, source net.Addr, latency time.Duration, err error) {
+	for _, rc := range s.stats.counters(listener, source) {
+		rc.handlerCalls.Add(1)
+		rc.handlerTime.Add(int64(latency))
+		if err != nil {
+			rc.handlerErrors.Add(1)
+		}
+	}
+	if s.metricsHook != nil {
+		s.metricsHook.Handled(listener, source, latency, err)
+	}
+}
@@ -0,0 +1,205 @@
+package mbsyslog_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/venutios/mbsyslog"
+)
+
+//testHook counts the events passed to the metrics hook
+type testHook struct {
+	mutex  sync.Mutex
+	events map[string]int
+}
+
+func (h *testHook) count(event string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.events[event]++
+}
+
+func (h *testHook) Received(listener string, source net.Addr, bytes int, truncated bool) {
+	h.count("received")
+}
+
+func (h *testHook) Dropped(listener string, source net.Addr, policy mbsyslog.OverflowPolicy) {
+	h.count("dropped")
+}
+
+func (h *testHook) Parsed(listener string, source net.Addr, format mbsyslog.MessageFormat, err error) {
+	h.count("parsed")
+}
+
+func (h *testHook) Handled(listener string, source net.Addr, latency time.Duration, err error) {
+	h.count("handled")
+}
+
+//testServerStats sends messages to a UDP server in strict RFC 5424 mode and
+//shuts it down once they are handled
+func testServerStats(t *testing.T, hook mbsyslog.MetricsHook) *mbsyslog.Server {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+
+	handler := mbsyslog.HandlerFunc(func(ctx context.Context, m *mbsyslog.Message) error {
+		if m.Content() == "fail" {
+			return errors.New("failed")
+		}
+		return nil
+	})
+	s := mbsyslog.NewHandlerServer(handler, mbsyslog.WithListenerConn(conn), mbsyslog.WithMaxMessageSize(40),
+		mbsyslog.WithParseOptions(mbsyslog.ParseOptions{Mode: mbsyslog.ParseModeRFC5424}), mbsyslog.WithMetricsHook(hook))
+	go s.Serve(context.Background())
+	startTime := time.Now()
+	for !s.Running() {
+		if time.Now().Sub(startTime).Seconds() >= 10 {
+			t.Fatal("Server failed to start running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	client := mbsyslog.NewClient(true)
+	for _, data := range []string{
+		"<13>1 - - - - - - ok",
+		"<13>1 - - - - - - fail",
+		"<13>not RFC 5424",
+		"<13>1 - - - - - - " + strings.Repeat("x", 40),
+	} {
+		if err := client.SendData(conn.LocalAddr().String(), []byte(data)); err != nil {
+			t.Fatalf("Client.SendData() error: %s", err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Stats().Total.Datagrams < 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	shutdown(t, s)
+	return s
+}
+
+func TestServer_Stats(t *testing.T) {
+	hook := &testHook{events: make(map[string]int)}
+	s := testServerStats(t, hook)
+	stats := s.Stats()
+
+	want := mbsyslog.ReceiveStats{
+		Datagrams:     4,
+		Bytes:         20 + 22 + 16 + 40,
+		Truncated:     1,
+		ParseFailures: 1,
+		Formats:       map[mbsyslog.MessageFormat]uint64{mbsyslog.MessageFormatRFC5424: 3},
+		HandlerErrors: 1,
+		HandlerCalls:  3,
+	}
+	check := func(name string, got mbsyslog.ReceiveStats) {
+		got.HandlerTime = 0
+		if got.Datagrams != want.Datagrams || got.Bytes != want.Bytes || got.Truncated != want.Truncated ||
+			got.ParseFailures != want.ParseFailures || got.HandlerErrors != want.HandlerErrors ||
+			got.HandlerCalls != want.HandlerCalls || got.Formats[mbsyslog.MessageFormatRFC5424] != 3 || len(got.Formats) != 1 {
+			t.Errorf("Server.Stats() %s = %+v, want %+v", name, got, want)
+		}
+	}
+	check("total", stats.Total)
+	if len(stats.Listeners) != 1 {
+		t.Errorf("Server.Stats() listeners = %v, want 1", stats.Listeners)
+	}
+	for name, listener := range stats.Listeners {
+		if !strings.HasPrefix(name, "udp 127.0.0.1:") {
+			t.Errorf("Server.Stats() listener name = %v", name)
+		}
+		check(name, listener)
+	}
+	source, found := stats.Sources["127.0.0.1"]
+	if !found || len(stats.Sources) != 1 {
+		t.Fatalf("Server.Stats() sources = %v, want 127.0.0.1", stats.Sources)
+	}
+	check("source", source)
+
+	//the snapshot doesn't change with the server
+	stats.Total.Formats[mbsyslog.MessageFormatRFC5424] = 100
+	if got := s.Stats().Total.Formats[mbsyslog.MessageFormatRFC5424]; got != 3 {
+		t.Errorf("Server.Stats() shares the format counts, got %v", got)
+	}
+
+	wantEvents := map[string]int{"received": 4, "parsed": 4, "handled": 3}
+	for event, count := range wantEvents {
+		if hook.events[event] != count {
+			t.Errorf("MetricsHook %s called %d times, want %d", event, hook.events[event], count)
+		}
+	}
+}
+
+func TestServer_MetricsHandler(t *testing.T) {
+	s := testServerStats(t, nil)
+
+	recorder := httptest.NewRecorder()
+	s.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("MetricsHandler() content type = %v", contentType)
+	}
+
+	body := recorder.Body.String()
+	for _, want := range []string{
+		"# TYPE mbsyslog_received_datagrams_total counter\n",
+		"mbsyslog_received_bytes_total{listener=\"udp 127.0.0.1:",
+		"mbsyslog_source_received_datagrams_total{source=\"127.0.0.1\"} 4\n",
+		"mbsyslog_source_truncated_total{source=\"127.0.0.1\"} 1\n",
+		"mbsyslog_source_parse_failures_total{source=\"127.0.0.1\"} 1\n",
+		"mbsyslog_source_handler_errors_total{source=\"127.0.0.1\"} 1\n",
+		"mbsyslog_source_messages_total{source=\"127.0.0.1\",format=\"RFC5424\"} 3\n",
+		"mbsyslog_source_messages_total{source=\"127.0.0.1\",format=\"RFC3164\"} 0\n",
+		"# TYPE mbsyslog_handler_duration_seconds summary\n",
+		"mbsyslog_source_handler_duration_seconds_count{source=\"127.0.0.1\"} 3\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("MetricsHandler() missing %q in:\n%s", want, body)
+		}
+	}
+}
+
+func TestServer_StatsTolerant(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	messages := make(chan mbsyslog.Message, 5)
+	s := mbsyslog.NewServer(messages, mbsyslog.WithListenerConn(conn))
+	go s.Serve(context.Background())
+	startTime := time.Now()
+	for !s.Running() {
+		if time.Now().Sub(startTime).Seconds() >= 10 {
+			t.Fatal("Server failed to start running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	//a message without a priority is passed on, and counted as a failure
+	client := mbsyslog.NewClient(true)
+	for _, data := range []string{"<13>1 - - - - - - ok", "no priority"} {
+		if err := client.SendData(conn.LocalAddr().String(), []byte(data)); err != nil {
+			t.Fatalf("Client.SendData() error: %s", err)
+		}
+	}
+	for range 2 {
+		select {
+		case <-messages:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Server never passed on the messages")
+		}
+	}
+	shutdown(t, s)
+
+	total := s.Stats().Total
+	if total.ParseFailures != 1 || total.Formats[mbsyslog.MessageFormatUnknown] != 1 || total.Formats[mbsyslog.MessageFormatRFC5424] != 1 {
+		t.Errorf("Server.Stats() = %+v, want one failure", total)
+	}
+}
@@ -1,5 +1,11 @@
 package mbsyslog
 
+import (
+	"errors"
+	"iter"
+	"strings"
+)
+
 //StructuredData is an optional part of the syslog message that holds a
 //sequence of elements, and each element is made up of multiple parameters.
 //Example with two elements, and different number of parameters:
@@ -27,6 +33,18 @@ func (sd *StructuredData) addElement(raw string) bool {
 	return false
 }
 
+//replaceElement adds the element, removing any elements with the same id the
+//sender included
+func (sd *StructuredData) replaceElement(e *Element) {
+	elements := sd.elements[:0:0]
+	for _, existing := range sd.elements {
+		if existing.id != e.id {
+			elements = append(elements, existing)
+		}
+	}
+	sd.elements = append(elements, e)
+}
+
 //Count returns the number of elements in the structured data
 func (sd StructuredData) Count() int {
 	return len(sd.elements)
@@ -36,3 +54,91 @@ func (sd StructuredData) Count() int {
 func (sd StructuredData) Element(index int) Element {
 	return *sd.elements[index]
 }
+
+//Lookup returns the first element with the id, and false if there isn't one.
+//For example Lookup("origin") finds [origin ip="192.0.2.1"].
+func (sd StructuredData) Lookup(id string) (Element, bool) {
+	for _, e := range sd.elements {
+		if e.id == id {
+			return *e, true
+		}
+	}
+	return Element{}, false
+}
+
+//All returns an iterator over the elements in the order they appear
+func (sd StructuredData) All() iter.Seq[Element] {
+	return func(yield func(Element) bool) {
+		for _, e := range sd.elements {
+			if !yield(*e) {
+				return
+			}
+		}
+	}
+}
+
+//Map returns the unescaped parameter values by element id and parameter name.
+//Values of repeated parameters, and of elements repeated in a leniently parsed
+//message, are kept in the order they appear. Elements without parameters have
+//an empty map.
+func (sd StructuredData) Map() map[string]map[string][]string {
+	result := make(map[string]map[string][]string, len(sd.elements))
+	for _, e := range sd.elements {
+		params, ok := result[e.id]
+		if !ok {
+			params = make(map[string][]string, len(e.parameters))
+			result[e.id] = params
+		}
+		for _, p := range e.parameters {
+			params[p.name] = append(params[p.name], e.value(p))
+		}
+	}
+	return result
+}
+
+//String returns the structured data in the RFC 5424 format, or the NILVALUE
+//dash when there are no elements
+func (sd StructuredData) String() string {
+	if len(sd.elements) == 0 {
+		return "-"
+	}
+
+	var result strings.Builder
+	for _, e := range sd.elements {
+		result.WriteString(e.String())
+	}
+	return result.String()
+}
+
+//validate checks every element is allowed by RFC 5424, and that no element
+//id is used more than once
+func (sd StructuredData) validate() error {
+	ids := make(map[string]bool, len(sd.elements))
+	for _, e := range sd.elements {
+		if err := e.validate(); err != nil {
+			return err
+		}
+		if ids[e.id] {
+			return errors.New("Duplicate structured data id " + e.id)
+		}
+		ids[e.id] = true
+	}
+	return nil
+}
+
+//findElementEnd returns the index of the ']' closing the element starting at
+//index, skipping any inside quoted parameter values, or -1 if there isn't one
+func findElementEnd(raw string, index int) int {
+	for ; index < len(raw); index++ {
+		switch raw[index] {
+		case '"':
+			index = findEndQuote(raw, index+1)
+			if index == -1 {
+				return -1
+			}
+		case ']':
+			return index
+		}
+	}
+	return -1
+}
@@ -0,0 +1,30 @@
+package mbsyslog
+
+//SyncPolicy decides how often a disk queue flushes messages to stable storage
+type SyncPolicy int
+
+const (
+	//SyncAlways flushes every message to disk before SendData returns, so no
+	//message accepted by the queue is lost in a crash
+	SyncAlways SyncPolicy = iota
+	//SyncPeriodic flushes the queue at the sync interval. Messages accepted
+	//since the last flush can be lost if the machine crashes.
+	SyncPeriodic
+	//SyncNever leaves flushing to the operating system. Messages survive the
+	//process exiting, but not the machine crashing.
+	SyncNever
+)
+
+//String returns the string representation of the SyncPolicy
+func (sp SyncPolicy) String() string {
+	switch sp {
+	case SyncAlways:
+		return "SyncAlways"
+	case SyncPeriodic:
+		return "SyncPeriodic"
+	case SyncNever:
+		return "SyncNever"
+	default:
+		return "Unknown"
+	}
+}
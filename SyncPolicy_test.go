@@ -0,0 +1,23 @@
+package mbsyslog
+
+import "testing"
+
+func TestSyncPolicy_String(t *testing.T) {
+	tests := []struct {
+		name string
+		sp   SyncPolicy
+		want string
+	}{
+		{"SyncAlways", SyncAlways, "SyncAlways"},
+		{"SyncPeriodic", SyncPeriodic, "SyncPeriodic"},
+		{"SyncNever", SyncNever, "SyncNever"},
+		{"SyncUnknown", 392, "Unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.sp.String(); got != tt.want {
+				t.Errorf("SyncPolicy.String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
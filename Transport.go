@@ -0,0 +1,51 @@
+package mbsyslog
+
+//Transport is the network protocol used by a client to deliver messages
+type Transport int
+
+const (
+	//TransportUDP sends each message as a single UDP datagram (RFC 5426)
+	TransportUDP Transport = iota
+	//TransportTCP sends messages over a persistent TCP connection using octet
+	//counting framing (RFC 6587)
+	TransportTCP
+	//TransportTLS sends messages over a persistent TLS connection using octet
+	//counting framing (RFC 5425)
+	TransportTLS
+	//TransportUnix sends messages to the local syslog daemon over a Unix
+	//socket, such as /dev/log, in the format of glibc syslog(3)
+	TransportUnix
+	//TransportRELP sends messages over the Reliable Event Logging Protocol,
+	//waiting for the server to acknowledge each message
+	TransportRELP
+)
+
+//String returns the string representation of the Transport
+func (t Transport) String() string {
+	switch t {
+	case TransportUDP:
+		return "TransportUDP"
+	case TransportTCP:
+		return "TransportTCP"
+	case TransportTLS:
+		return "TransportTLS"
+	case TransportUnix:
+		return "TransportUnix"
+	case TransportRELP:
+		return "TransportRELP"
+	default:
+		return "Unknown"
+	}
+}
+
+//defaultPort is the port used when a destination doesn't specify one
+func (t Transport) defaultPort() int {
+	switch t {
+	case TransportTLS:
+		return 6514
+	case TransportRELP:
+		return 2514
+	default:
+		return 514
+	}
+}
@@ -0,0 +1,25 @@
+package mbsyslog
+
+import "testing"
+
+func TestTransport_String(t *testing.T) {
+	tests := []struct {
+		name string
+		t    Transport
+		want string
+	}{
+		{"TransportUDP", TransportUDP, "TransportUDP"},
+		{"TransportTCP", TransportTCP, "TransportTCP"},
+		{"TransportTLS", TransportTLS, "TransportTLS"},
+		{"TransportUnix", TransportUnix, "TransportUnix"},
+		{"TransportRELP", TransportRELP, "TransportRELP"},
+		{"TransportUnknown", 392, "Unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.t.String(); got != tt.want {
+				t.Errorf("Transport.String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
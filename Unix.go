@@ -0,0 +1,168 @@
+package mbsyslog
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+)
+
+//DefaultSocketPath is where local programs send messages with syslog(3)
+const DefaultSocketPath = "/dev/log"
+
+//PeerCredentialsElementID is the id of the structured data element holding
+//the credentials of the process that sent a message over a Unix socket, with
+//the parameters pid, uid and gid. Any element with this id in the message is
+//replaced, so senders can't forge it.
+const PeerCredentialsElementID = "peer@" + defaultEnterpriseID
+
+//socketMode lets every user on the machine write to the socket, as they can
+//to the socket of a syslog daemon
+const socketMode = 0666
+
+//ListenUnix starts the server accepting syslog messages on a Unix datagram
+//socket. It is the same as ServeUnix with a background context, except nil is
+//returned once the server stops.
+func (s *Server) ListenUnix(path string) error {
+	return ignoreServerClosed(s.ServeUnix(context.Background(), path))
+}
+
+//ServeUnix accepts syslog messages on a Unix datagram socket at the path, such
+//as DefaultSocketPath, until the context is done or the server is shut down.
+//Messages in the local format of glibc syslog(3), which has no hostname, are
+//understood. On Linux the credentials of the sending process are added to
+//each message as structured data, see PeerCredentialsElementID. Datagrams are
+//queued like in Serve, and the socket file is removed when it returns.
+func (s *Server) ServeUnix(ctx context.Context, path string) error {
+	if err := removeSocket(path); err != nil {
+		return err
+	}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+
+	if err = os.Chmod(path, socketMode); err == nil {
+		err = enablePeerCredentials(conn)
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	oob := make([]byte, credentialsSpace)
+	return s.serveDatagrams(ctx, "unixgram "+path, true, conn, func(buffer []byte) (int, net.Addr, *Element, error) {
+		count, oobCount, _, addr, err := conn.ReadMsgUnix(buffer, oob)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		//some programs end the message with a newline or NUL like on a stream
+		count = len(bytes.TrimRight(buffer[:count], "\n\x00"))
+		//unbound senders have no address
+		var source net.Addr
+		if addr != nil {
+			source = addr
+		}
+		return count, source, datagramCredentials(oob[:oobCount]), nil
+	})
+}
+
+//ListenUnixStream starts the server accepting syslog messages on a Unix stream
+//socket. It is the same as ServeUnixStream with a background context, except
+//nil is returned once the server stops.
+func (s *Server) ListenUnixStream(path string) error {
+	return ignoreServerClosed(s.ServeUnixStream(context.Background(), path))
+}
+
+//ServeUnixStream accepts syslog messages on a Unix stream socket at the path
+//until the context is done or the server is shut down. Messages on each
+//connection are framed like ServeTCP, or ended by NUL as glibc does. Like
+//ServeUnix, the local format and peer credentials are supported.
+func (s *Server) ServeUnixStream(ctx context.Context, path string) error {
+	if err := removeSocket(path); err != nil {
+		return err
+	}
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(path, socketMode); err != nil {
+		listener.Close()
+		return err
+	}
+
+	return s.serveStream(ctx, "unix", listener, s.framedReader(framingUnknown), nil)
+}
+
+//removeSocket removes a socket left behind at the path, such as by a syslog
+//daemon that didn't exit cleanly. Other kinds of files are kept, so binding
+//the socket fails.
+func removeSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+	return os.Remove(path)
+}
+
+//peerElement is the structured data element with the credentials of the
+//process at the other end of a Unix socket
+func peerElement(pid int, uid int, gid int) *Element {
+	result := new(Element)
+	result.id = PeerCredentialsElementID
+	result.parameters = []*Parameter{
+		NewParameter("pid", strconv.Itoa(pid)),
+		NewParameter("uid", strconv.Itoa(uid)),
+		NewParameter("gid", strconv.Itoa(gid)),
+	}
+	return result
+}
+
+//unixSocketPaths are where the local syslog daemon usually listens, in the
+//order log/syslog tries them
+var unixSocketPaths = []string{DefaultSocketPath, "/var/run/syslog", "/var/run/log"}
+
+//dialUnix connects to the syslog socket at the path, or the first of the usual
+//paths that accepts a connection when it is empty. Datagram sockets are tried
+//before stream sockets, like log/syslog.
+func dialUnix(ctx context.Context, dialer *net.Dialer, path string) (net.Conn, error) {
+	paths := unixSocketPaths
+	if path != "" {
+		paths = []string{path}
+	}
+
+	for _, network := range []string{"unixgram", "unix"} {
+		for _, p := range paths {
+			conn, err := dialer.DialContext(ctx, network, p)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, err
+				}
+				continue
+			}
+			if network == "unix" {
+				return &nulTerminatedConn{conn}, nil
+			}
+			return conn, nil
+		}
+	}
+	return nil, errors.New("No syslog socket accepted the connection")
+}
+
+//nulTerminatedConn ends every message written to a Unix stream socket with
+//NUL, as glibc syslog(3) does
+type nulTerminatedConn struct {
+	net.Conn
+}
+
+func (c *nulTerminatedConn) Write(data []byte) (int, error) {
+	framed := make([]byte, len(data)+1)
+	copy(framed, data)
+	if _, err := c.Conn.Write(framed); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
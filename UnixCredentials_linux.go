@@ -0,0 +1,72 @@
+//go:build linux
+
+package mbsyslog
+
+import (
+	"net"
+	"syscall"
+)
+
+//credentialsSpace is the size of the control message holding the credentials
+//of the sender of a datagram
+var credentialsSpace = syscall.CmsgSpace(syscall.SizeofUcred)
+
+//enablePeerCredentials asks the kernel to include the credentials of the
+//sender with every datagram (SO_PASSCRED)
+func enablePeerCredentials(conn *net.UnixConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var optErr error
+	err = raw.Control(func(fd uintptr) {
+		optErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_PASSCRED, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return optErr
+}
+
+//datagramCredentials reads the credentials of the sender from the control
+//messages received with a datagram, or returns nil if there are none
+func datagramCredentials(oob []byte) *Element {
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+	for _, message := range messages {
+		if message.Header.Level != syscall.SOL_SOCKET || message.Header.Type != syscall.SCM_CREDENTIALS {
+			continue
+		}
+		credentials, err := syscall.ParseUnixCredentials(&message)
+		if err == nil {
+			return peerElement(int(credentials.Pid), int(credentials.Uid), int(credentials.Gid))
+		}
+	}
+	return nil
+}
+
+//connCredentials returns the credentials of the process that connected to a
+//Unix stream socket (SO_PEERCRED), or nil for other connections
+func connCredentials(conn net.Conn) *Element {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+
+	var credentials *syscall.Ucred
+	var optErr error
+	err = raw.Control(func(fd uintptr) {
+		credentials, optErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || optErr != nil {
+		return nil
+	}
+	return peerElement(int(credentials.Pid), int(credentials.Uid), int(credentials.Gid))
+}
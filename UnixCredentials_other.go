@@ -0,0 +1,20 @@
+//go:build !linux
+
+package mbsyslog
+
+import "net"
+
+//credentialsSpace is zero as peer credentials are only supported on Linux
+var credentialsSpace = 0
+
+func enablePeerCredentials(conn *net.UnixConn) error {
+	return nil
+}
+
+func datagramCredentials(oob []byte) *Element {
+	return nil
+}
+
+func connCredentials(conn net.Conn) *Element {
+	return nil
+}
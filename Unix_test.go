@@ -0,0 +1,287 @@
+package mbsyslog_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/venutios/mbsyslog"
+)
+
+//peerCredentials returns the parameters of the peer credentials element, or
+//nil if the message doesn't have one
+func peerCredentials(m mbsyslog.Message) map[string]string {
+	sd := m.StructuredData()
+	for index := 0; index < sd.Count(); index++ {
+		e := sd.Element(index)
+		if e.ID() != mbsyslog.PeerCredentialsElementID {
+			continue
+		}
+		result := make(map[string]string)
+		for param := 0; param < e.Count(); param++ {
+			result[e.Parameter(param).Name()] = e.Parameter(param).Value()
+		}
+		return result
+	}
+	return nil
+}
+
+//checkLocalMessage verifies a message sent from this process over a Unix
+//socket in the glibc format
+func checkLocalMessage(t *testing.T, m mbsyslog.Message, content string) {
+	t.Helper()
+	if m.Format() != mbsyslog.MessageFormatRFC3164 {
+		t.Errorf("Message.Format() = %v, want %v", m.Format(), mbsyslog.MessageFormatRFC3164)
+	}
+	if m.Hostname() != "" || m.Application() != "myapp" || m.ProcessID() != 123 {
+		t.Errorf("Message hostname, application, process ID = %q, %q, %d", m.Hostname(), m.Application(), m.ProcessID())
+	}
+	if m.Content() != content {
+		t.Errorf("Message.Content() = %q, want %q", m.Content(), content)
+	}
+
+	credentials := peerCredentials(m)
+	if runtime.GOOS != "linux" {
+		return
+	}
+	if credentials["pid"] != strconv.Itoa(os.Getpid()) || credentials["uid"] != strconv.Itoa(os.Getuid()) || credentials["gid"] != strconv.Itoa(os.Getgid()) {
+		t.Errorf("Message peer credentials = %v", credentials)
+	}
+}
+
+func TestServer_Unix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+
+	//a socket left behind is replaced
+	stale, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("Unix datagram sockets aren't supported: %s", err)
+	}
+	stale.Close()
+
+	messages := make(chan mbsyslog.Message, 5)
+	s := mbsyslog.NewServer(messages)
+	served := make(chan error, 1)
+	go func() {
+		served <- s.ServeUnix(context.Background(), path)
+	}()
+	startTime := time.Now()
+	for !s.Running() {
+		if time.Now().Sub(startTime).Seconds() >= 10 {
+			t.Fatal("Server failed to start running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	conn, err := net.Dial("unixgram", path)
+	if err != nil {
+		t.Fatalf("Failed to connect: %s", err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		name    string
+		data    string
+		content string
+	}{
+		{"Local", "<13>Oct 18 10:00:00 myapp[123]: hello", "hello"},
+		{"Newline", "<13>Oct 18 10:00:00 myapp[123]: newline\n", "newline"},
+		{"NUL", "<13>Oct 18 10:00:00 myapp[123]: nul\x00", "nul"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := conn.Write([]byte(tt.data)); err != nil {
+				t.Fatalf("Failed to write: %s", err)
+			}
+			select {
+			case m := <-messages:
+				checkLocalMessage(t, m, tt.content)
+			case <-time.After(5 * time.Second):
+				t.Fatal("Server.ServeUnix() message never received")
+			}
+		})
+	}
+
+	//the sender can't forge the credentials element
+	if _, err := conn.Write([]byte("<13>1 - host myapp 123 - [" + mbsyslog.PeerCredentialsElementID + " pid=\"1\" uid=\"0\" gid=\"0\"] forged")); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+	select {
+	case m := <-messages:
+		credentials := peerCredentials(m)
+		if m.StructuredData().Count() != 1 || (runtime.GOOS == "linux" && credentials["pid"] != strconv.Itoa(os.Getpid())) {
+			t.Errorf("Message structured data = %v", m.StructuredData())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server.ServeUnix() message never received")
+	}
+
+	shutdown(t, s)
+	if err := <-served; err != mbsyslog.ErrServerClosed {
+		t.Errorf("Server.ServeUnix() error = %v, want %v", err, mbsyslog.ErrServerClosed)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Server.ServeUnix() left the socket behind: %v", err)
+	}
+	if _, found := s.Stats().Sources["local"]; !found {
+		t.Errorf("Server.Stats() sources = %v, want local", s.Stats().Sources)
+	}
+}
+
+func TestServer_UnixStream(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+
+	messages := make(chan mbsyslog.Message, 5)
+	s := mbsyslog.NewServer(messages)
+	go s.ServeUnixStream(context.Background(), path)
+	defer shutdown(t, s)
+	startTime := time.Now()
+	for !s.Running() {
+		if time.Now().Sub(startTime).Seconds() >= 10 {
+			t.Fatal("Server failed to start running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to connect: %s", err)
+	}
+	defer conn.Close()
+
+	//glibc ends each message with NUL on stream sockets
+	if _, err := conn.Write([]byte("<13>Oct 18 10:00:00 myapp[123]: first\x00<13>Oct 18 10:00:01 myapp[123]: second\x00")); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+	for _, content := range []string{"first", "second"} {
+		select {
+		case m := <-messages:
+			checkLocalMessage(t, m, content)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Server.ServeUnixStream() message never received")
+		}
+	}
+}
+
+func TestClient_Unix(t *testing.T) {
+	m, err := mbsyslog.NewMessageBuilder().
+		Facility(mbsyslog.MessageFacilityAuth).
+		Severity(mbsyslog.MessageSeverityCritical).
+		Timestamp(time.Date(2003, time.October, 11, 22, 14, 15, 0, time.UTC)).
+		Hostname("mymachine.example.com").
+		Application("su").
+		ProcessID(1234).
+		Content("'su root' failed for lonvick on /dev/pts/8").
+		Build()
+	if err != nil {
+		t.Fatalf("MessageBuilder.Build() error: %s", err)
+	}
+	want := "<34>Oct 11 22:14:15 su[1234]: 'su root' failed for lonvick on /dev/pts/8"
+
+	t.Run("Datagram", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "log")
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+		if err != nil {
+			t.Skipf("Unix datagram sockets aren't supported: %s", err)
+		}
+		defer conn.Close()
+
+		client := mbsyslog.NewClient(true,
+			mbsyslog.WithTransport(mbsyslog.TransportUnix),
+			mbsyslog.WithDestination(path),
+			mbsyslog.WithMessageFormat(mbsyslog.MessageFormatRFC5424))
+		defer client.Close()
+		if err := client.Send(m); err != nil {
+			t.Fatalf("Client.Send() error: %s", err)
+		}
+
+		buffer := make([]byte, 8192)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		count, err := conn.Read(buffer)
+		if err != nil {
+			t.Fatalf("Client.Send() message never received: %s", err)
+		}
+		if got := string(buffer[:count]); got != want {
+			t.Errorf("Client.Send() sent %q, want %q", got, want)
+		}
+	})
+
+	//a stream socket is used when the path doesn't accept datagrams
+	t.Run("Stream", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "log")
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatalf("Failed to listen: %s", err)
+		}
+		defer listener.Close()
+
+		client := mbsyslog.NewClient(true,
+			mbsyslog.WithTransport(mbsyslog.TransportUnix),
+			mbsyslog.WithDestination(path))
+		defer client.Close()
+		if err := client.Send(m); err != nil {
+			t.Fatalf("Client.Send() error: %s", err)
+		}
+
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Fatalf("Failed to accept: %s", err)
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		got, err := bufio.NewReader(conn).ReadString(0)
+		if err != nil {
+			t.Fatalf("Client.Send() message never received: %s", err)
+		}
+		if got != want+"\x00" {
+			t.Errorf("Client.Send() sent %q, want %q", got, want+"\x00")
+		}
+	})
+
+	t.Run("NoSocket", func(t *testing.T) {
+		client := mbsyslog.NewClient(true,
+			mbsyslog.WithTransport(mbsyslog.TransportUnix),
+			mbsyslog.WithDestination(filepath.Join(t.TempDir(), "missing")))
+		if err := client.Send(m); err == nil {
+			t.Error("Client.Send() error = nil, want an error")
+		}
+	})
+
+	//the server parses what the client writes
+	t.Run("Server", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "log")
+		messages := make(chan mbsyslog.Message, 5)
+		s := mbsyslog.NewServer(messages)
+		go s.ServeUnix(context.Background(), path)
+		defer shutdown(t, s)
+		startTime := time.Now()
+		for !s.Running() {
+			if time.Now().Sub(startTime).Seconds() >= 10 {
+				t.Fatal("Server failed to start running")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		client := mbsyslog.NewClient(true,
+			mbsyslog.WithTransport(mbsyslog.TransportUnix),
+			mbsyslog.WithDestination(path),
+			mbsyslog.WithApplication("myapp"),
+			mbsyslog.WithProcessID(123))
+		defer client.Close()
+		if err := client.Info("hello"); err != nil {
+			t.Fatalf("Client.Info() error: %s", err)
+		}
+		select {
+		case m := <-messages:
+			checkLocalMessage(t, m, "hello")
+		case <-time.After(5 * time.Second):
+			t.Fatal("Server.ServeUnix() message never received")
+		}
+	})
+}